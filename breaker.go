@@ -0,0 +1,98 @@
+package wrigi
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type circuitBreaker struct {
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+var (
+	BreakerThreshold = 5
+	BreakerCooldown  = 5 * time.Minute
+
+	breakers     = map[string]*circuitBreaker{}
+	breakersLock sync.Mutex
+
+	errCircuitOpen = errors.New("too many consecutive failures, skipping fetch")
+)
+
+func hostOf(rawurl string) string {
+	parsed, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	return parsed.Host
+}
+
+func (b *circuitBreaker) state(now time.Time) string {
+	switch {
+	case BreakerThreshold <= 0 || b.failures < BreakerThreshold:
+		return breakerClosed
+	case b.probing || now.Sub(b.openedAt) >= BreakerCooldown:
+		return breakerHalfOpen
+	default:
+		return breakerOpen
+	}
+}
+
+func breakerState(host string, now time.Time) string {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	if b, ok := breakers[host]; ok {
+		return b.state(now)
+	}
+	return breakerClosed
+}
+
+// breakerAllow reports whether a call to host may go out. Once the cooldown
+// has passed a single probe is let through; the next one waits for another
+// cooldown unless the probe reports back first.
+func breakerAllow(host string, now time.Time) bool {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	b, ok := breakers[host]
+	if !ok || b.state(now) == breakerClosed {
+		return true
+	}
+	if now.Sub(b.openedAt) < BreakerCooldown {
+		return false
+	}
+	b.openedAt = now
+	b.probing = true
+	return true
+}
+
+func breakerRecord(host string, success bool, now time.Time) {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	b, ok := breakers[host]
+	if !ok {
+		b = &circuitBreaker{}
+		breakers[host] = b
+	}
+	b.probing = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= BreakerThreshold {
+		b.openedAt = now
+	}
+}
@@ -0,0 +1,121 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	threshold, cooldown := BreakerThreshold, BreakerCooldown
+	BreakerThreshold, BreakerCooldown = 2, time.Minute
+	t.Cleanup(func() {
+		BreakerThreshold, BreakerCooldown = threshold, cooldown
+		breakersLock.Lock()
+		breakers = map[string]*circuitBreaker{}
+		breakersLock.Unlock()
+	})
+
+	const host = "api.example.com"
+	start := time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)
+	steps := []struct {
+		name    string
+		at      time.Duration
+		record  string
+		allowed bool
+		state   string
+	}{
+		{name: "first failure", record: "failure", allowed: true, state: breakerClosed},
+		{name: "opens", record: "failure", allowed: true, state: breakerOpen},
+		{name: "during the cooldown", at: 30 * time.Second, state: breakerOpen},
+		{name: "probe after the cooldown", at: time.Minute, allowed: true, state: breakerHalfOpen},
+		{name: "single probe", at: time.Minute + time.Second, state: breakerHalfOpen},
+		{name: "failed probe reopens", at: time.Minute + 2*time.Second, record: "failure", state: breakerOpen},
+		{name: "second probe", at: 2*time.Minute + 2*time.Second, allowed: true, state: breakerHalfOpen},
+		{name: "successful probe closes", at: 2*time.Minute + 3*time.Second, record: "success", state: breakerClosed},
+		{name: "closed", at: 2*time.Minute + 4*time.Second, allowed: true, state: breakerClosed},
+	}
+
+	for _, step := range steps {
+		now := start.Add(step.at)
+		if allowed := breakerAllow(host, now); allowed != step.allowed {
+			t.Errorf("%s: got allowed=%v, want %v", step.name, allowed, step.allowed)
+		}
+		if step.record != "" {
+			breakerRecord(host, step.record == "success", now)
+		}
+		if state := breakerState(host, now); state != step.state {
+			t.Errorf("%s: got state %s, want %s", step.name, state, step.state)
+		}
+	}
+}
+
+func TestCircuitBreakerUpdates(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "fetchAttempts": 1, "breakerThreshold": 2, "breakerCooldown": "1h",
+		"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	var requests, failing int32
+	atomic.StoreInt32(&failing, 1)
+	releases := releasePages(&requests, []string{githubRelease("1.0.0", false)})
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			if r.URL.Path == "/repos/acme/plugin/releases" {
+				atomic.AddInt32(&requests, 1)
+			}
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		releases(w, r)
+	})
+
+	update := func() {
+		r := httptest.NewRequest("GET", "/cron/update", nil)
+		r.Header.Set("Authorization", "Bearer s3cret")
+		serve(r)
+	}
+	circuit := func() string {
+		var stats []RepositoryStats
+		w := serve(httptest.NewRequest("GET", "/stats", nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || len(stats) != 1 {
+			t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+		return stats[0].Circuit
+	}
+
+	update()
+	update()
+	if state := circuit(); state != breakerOpen {
+		t.Fatalf("got the circuit %s after two failures, want open", state)
+	}
+	update()
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("got %d release requests, want the open circuit to skip the third", got)
+	}
+
+	breakersLock.Lock()
+	for _, b := range breakers {
+		b.openedAt = b.openedAt.Add(-time.Hour)
+	}
+	breakersLock.Unlock()
+	if state := circuit(); state != breakerHalfOpen {
+		t.Fatalf("got the circuit %s after the cooldown, want half-open", state)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	update()
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Errorf("got %d release requests, want the probe to go out", got)
+	}
+	if state := circuit(); state != breakerClosed {
+		t.Errorf("got the circuit %s after a successful probe, want closed", state)
+	}
+	if body := serve(httptest.NewRequest("GET", "/acme/plugin/release.xml", nil)).Body.String(); !strings.Contains(body, "<version>1.0.0</version>") {
+		t.Errorf("got %s, want the fetched release", body)
+	}
+}
@@ -0,0 +1,96 @@
+package wrigi
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type cacheResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buffer bytes.Buffer
+}
+
+func (w *cacheResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *cacheResponseWriter) Write(data []byte) (int, error) {
+	return w.buffer.Write(data)
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// lastModified returns when the data behind r last changed: the last
+// successful fetch of the requested repository, or of any repository for
+// the endpoints that list them all, unless the config was reloaded since.
+func lastModified(r *http.Request) time.Time {
+	var modified time.Time
+	vars := mux.Vars(r)
+	if vars["repository"] != "" {
+		repository, _ := findRepository(vars["owner"], vars["repository"])
+		modified = repository.lastSuccess
+	} else {
+		modified, _ = lastSuccessfulUpdate()
+	}
+
+	repositoriesLock.RLock()
+	reloaded := configReloaded
+	repositoriesLock.RUnlock()
+	if reloaded.After(modified) {
+		return reloaded
+	}
+	return modified
+}
+
+func cacheHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cw := &cacheResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		handler(cw, r)
+
+		if cw.status != http.StatusOK {
+			w.WriteHeader(cw.status)
+			w.Write(cw.buffer.Bytes())
+			return
+		}
+
+		modified := lastModified(r).UTC().Truncate(time.Second)
+
+		etag := fmt.Sprintf(`W/"%x"`, sha1.Sum(cw.buffer.Bytes()))
+		w.Header().Set("ETag", etag)
+		if !modified.IsZero() {
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		}
+
+		notModified := false
+		if match := r.Header.Get("If-None-Match"); match != "" {
+			notModified = etagMatches(match, etag)
+		} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.IsZero() {
+			notModified = !modified.After(since)
+		}
+		if notModified {
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Encoding")
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.WriteHeader(cw.status)
+		w.Write(cw.buffer.Bytes())
+	}
+}
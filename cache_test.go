@@ -0,0 +1,100 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	updated := time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		value  string
+		status int
+	}{
+		{name: "plain", url: "/acme/plugin/release.xml", status: http.StatusOK},
+		{name: "plain root", url: "/", status: http.StatusOK},
+		{name: "ETag", url: "/acme/plugin/release.xml", header: "If-None-Match", value: "etag", status: http.StatusNotModified},
+		{name: "ETag among others", url: "/acme/plugin/release.xml", header: "If-None-Match", value: `"other", etag`, status: http.StatusNotModified},
+		{name: "other ETag", url: "/acme/plugin/release.xml", header: "If-None-Match", value: `W/"other"`, status: http.StatusOK},
+		{name: "not modified", url: "/acme/plugin/release.xml", header: "If-Modified-Since", value: updated.Format(http.TimeFormat), status: http.StatusNotModified},
+		{name: "not modified root", url: "/", header: "If-Modified-Since", value: updated.Add(time.Hour).Format(http.TimeFormat), status: http.StatusNotModified},
+		{name: "modified", url: "/acme/plugin/release.xml", header: "If-Modified-Since", value: updated.Add(-time.Second).Format(http.TimeFormat), status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			}
+			repositories[0].Repositories[0].lastSuccess = updated
+
+			first := serve(httptest.NewRequest("GET", test.url, nil))
+			etag := first.Header().Get("ETag")
+			if first.Code != http.StatusOK || len(etag) < 4 || etag[:3] != `W/"` {
+				t.Fatalf("got status %d and ETag %q, want 200 and a weak ETag", first.Code, etag)
+			}
+			if modified := first.Header().Get("Last-Modified"); modified != updated.Format(http.TimeFormat) {
+				t.Errorf("got Last-Modified %q, want %q", modified, updated.Format(http.TimeFormat))
+			}
+
+			r := httptest.NewRequest("GET", test.url, nil)
+			if test.header != "" {
+				value := test.value
+				if test.header == "If-None-Match" {
+					value = strings.Replace(value, "etag", etag, 1)
+				}
+				r.Header.Set(test.header, value)
+			}
+			w := serve(r)
+			if w.Code != test.status {
+				t.Fatalf("got status %d, want %d", w.Code, test.status)
+			}
+			if test.status == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("got a %d byte body with the 304", w.Body.Len())
+			}
+			if test.status == http.StatusOK && w.Body.String() != first.Body.String() {
+				t.Errorf("got %s, want %s", w.Body, first.Body)
+			}
+		})
+	}
+}
+
+func TestCacheHeadersReload(t *testing.T) {
+	config := `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`
+	useConfig(t, config)
+	updated := time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)
+	repositories[0].Repositories[0].Versions = RepositoryVersions{
+		"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+	}
+	repositories[0].Repositories[0].lastSuccess = updated
+
+	useConfigFile(t, config)
+	reload := httptest.NewRequest("POST", "/admin/reload", nil)
+	reload.Header.Set("Authorization", "Bearer s3cret")
+	before := time.Now().UTC().Truncate(time.Second)
+	if w := serve(reload); w.Code != http.StatusOK {
+		t.Fatalf("reloading: got status %d: %s", w.Code, w.Body)
+	}
+
+	r := httptest.NewRequest("GET", "/acme/plugin/release.xml", nil)
+	r.Header.Set("If-Modified-Since", updated.Format(http.TimeFormat))
+	w := serve(r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d after a reload, want 200", w.Code)
+	}
+	modified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil || modified.Before(before) {
+		t.Errorf("got Last-Modified %q, want the reload time", w.Header().Get("Last-Modified"))
+	}
+}
@@ -0,0 +1,237 @@
+package wrigi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+const draftChannel = "draft"
+
+type (
+	ChannelPattern struct {
+		Name    string
+		Pattern string
+
+		re *regexp.Regexp
+	}
+
+	ChannelInfo struct {
+		Name    string `xml:"name,attr"`
+		Version string `xml:"version,attr"`
+		Tag     string `xml:"tag,attr"`
+		Date    int64  `xml:"date,attr"`
+	}
+
+	ChannelList struct {
+		Channels []ChannelInfo `xml:"channel"`
+		XMLName  struct{}      `xml:"channels" json:"-"`
+	}
+)
+
+var (
+	defaultChannels = []ChannelPattern{
+		{Name: "alpha", Pattern: "alpha", re: regexp.MustCompile("alpha")},
+		{Name: "beta", Pattern: "beta", re: regexp.MustCompile("beta")},
+		{Name: "release", Pattern: "release", re: regexp.MustCompile("release")},
+	}
+
+	ChannelOrder  = []string{"release", "beta", "alpha"}
+	IncludeDrafts bool
+
+	legacyChannelKeys = map[string]string{
+		"alpha":   "Alpha",
+		"beta":    "Beta",
+		"release": "Release",
+	}
+)
+
+func (versions RepositoryVersions) MarshalJSON() ([]byte, error) {
+	out := make(map[string]Version, len(versions)+len(legacyChannelKeys))
+	for channel, key := range legacyChannelKeys {
+		out[key] = versions[channel]
+	}
+	for channel, version := range versions {
+		if _, legacy := legacyChannelKeys[channel]; !legacy {
+			out[channel] = version
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (versions *RepositoryVersions) UnmarshalJSON(data []byte) error {
+	var raw map[string]Version
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*versions = RepositoryVersions{}
+	for key, version := range raw {
+		if version.Name == "" {
+			continue
+		}
+		channel := key
+		for legacy, legacyKey := range legacyChannelKeys {
+			if key == legacyKey {
+				channel = legacy
+			}
+		}
+		(*versions)[channel] = version
+	}
+	return nil
+}
+
+func (repository Repository) channels() []ChannelPattern {
+	if len(repository.Channels) > 0 {
+		return repository.Channels
+	}
+	return defaultChannels
+}
+
+// listedChannels returns the channels that have a version and that r has
+// opted into, ordered by ChannelOrder and then by name.
+func (repository Repository) listedChannels(r *http.Request) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, channel := range repository.channels() {
+		if !seen[channel.Name] && repository.Versions[channel.Name].Name != "" && repository.optedIn(r, channel.Name) {
+			seen[channel.Name] = true
+			names = append(names, channel.Name)
+		}
+	}
+	if IncludeDrafts && !seen[draftChannel] && repository.Versions[draftChannel].Name != "" && repository.optedIn(r, draftChannel) {
+		names = append(names, draftChannel)
+	}
+
+	rank := func(name string) int {
+		for idx, channel := range ChannelOrder {
+			if channel == name {
+				return idx
+			}
+		}
+		return len(ChannelOrder)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		if rank(names[i]) != rank(names[j]) {
+			return rank(names[i]) < rank(names[j])
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func (repository Repository) hasChannel(name string) bool {
+	if name == draftChannel && IncludeDrafts {
+		return true
+	}
+	for _, channel := range repository.channels() {
+		if channel.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (repository Repository) channelsFilled() bool {
+	for _, channel := range repository.channels() {
+		if repository.Versions[channel.Name].Name == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// compilePatterns compiles the repository's channel and asset patterns so
+// that releaseChannel and selectAsset don't have to.
+func (repository *Repository) compilePatterns() error {
+	for idx, channel := range repository.Channels {
+		re, err := regexp.Compile(channel.Pattern)
+		if err != nil {
+			return fmt.Errorf("channel %s: %v", channel.Name, err)
+		}
+		repository.Channels[idx].re = re
+	}
+
+	repository.assetPattern = nil
+	if repository.AssetPattern != "" {
+		re, err := regexp.Compile(repository.AssetPattern)
+		if err != nil {
+			return fmt.Errorf("assetPattern: %v", err)
+		}
+		repository.assetPattern = re
+	}
+
+	repository.suffix = nil
+	if repository.ChannelSuffixPattern != "" {
+		re, err := regexp.Compile(repository.ChannelSuffixPattern)
+		if err != nil {
+			return fmt.Errorf("channelSuffixPattern: %v", err)
+		}
+		repository.suffix = re
+	}
+
+	repository.assetByOS = nil
+	for os, pattern := range repository.AssetByOS {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("assetByOS %s: %v", os, err)
+		}
+		if repository.assetByOS == nil {
+			repository.assetByOS = map[string]*regexp.Regexp{}
+		}
+		repository.assetByOS[os] = re
+	}
+	return nil
+}
+
+func (repository Repository) releaseChannel(release GithubRelease) string {
+	for _, name := range []string{release.Name, release.TagName} {
+		for _, channel := range repository.channels() {
+			if channel.re != nil && channel.re.MatchString(name) {
+				return channel.Name
+			}
+		}
+	}
+
+	if release.Prerelease && repository.hasChannel("beta") {
+		return "beta"
+	}
+	if !release.Prerelease && repository.hasChannel("release") {
+		return "release"
+	}
+	return ""
+}
+
+func channelsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	repository, ok := findRepository(vars["owner"], vars["repository"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	list := ChannelList{Channels: []ChannelInfo{}}
+	for _, channel := range repository.listedChannels(r) {
+		version := repository.Versions[channel]
+		list.Channels = append(list.Channels, ChannelInfo{
+			Name:    channel,
+			Version: repository.displayVersion(version.Name),
+			Tag:     version.Tag,
+			Date:    version.Date,
+		})
+	}
+
+	format := negotiateFormat(r, vars["format"])
+	response, err := marshalFormat(w, r, format, list)
+	if err != nil {
+		newContext(r).Errorf("encoding %s: %v", r.URL.Path, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
@@ -0,0 +1,221 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const nightlyConfig = `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin",
+	"channels": [{"name": "nightly", "pattern": "-nightly\\."}, {"name": "release", "pattern": "^v?[0-9.]+$"}]}]}]}`
+
+func TestCustomChannels(t *testing.T) {
+	tests := []struct {
+		name     string
+		releases []string
+		nightly  string
+		release  string
+	}{
+		{
+			name:     "nightly and release",
+			releases: []string{githubRelease("1.1.0-nightly.2", true), githubRelease("1.0.0", false)},
+			nightly:  "1.1.0-nightly.2",
+			release:  "1.0.0",
+		},
+		{
+			name:     "newest nightly",
+			releases: []string{githubRelease("1.1.0-nightly.1", true), githubRelease("1.1.0-nightly.3", true), githubRelease("1.0.0", false)},
+			nightly:  "1.1.0-nightly.3",
+			release:  "1.0.0",
+		},
+		{
+			name:     "prereleases without a channel are skipped",
+			releases: []string{githubRelease("1.1.0-rc1", true), githubRelease("1.0.0", false)},
+			release:  "1.0.0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, nightlyConfig)
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/acme/plugin/releases" {
+					fmt.Fprint(w, `{"stargazers_count": 10}`)
+					return
+				}
+				fmt.Fprintf(w, "[%s]", strings.Join(test.releases, ","))
+			})
+
+			repository, err := updateRepository(httptest.NewRequest("GET", "/update", nil), "acme", repositories[0].Repositories[0])
+			if err != nil {
+				t.Fatalf("updating: %v", err)
+			}
+			if got := repository.Versions["nightly"].Name; got != test.nightly {
+				t.Errorf("got nightly %q, want %q", got, test.nightly)
+			}
+			if got := repository.Versions["release"].Name; got != test.release {
+				t.Errorf("got release %q, want %q", got, test.release)
+			}
+			if _, ok := repository.Versions["beta"]; ok {
+				t.Error("got a beta version without a beta channel")
+			}
+			storeFetched("acme", repository)
+
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/nightly.xml", nil))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<version>"+test.nightly+"</version>") {
+				t.Errorf("got status %d and %s, want nightly %q", w.Code, w.Body, test.nightly)
+			}
+		})
+	}
+}
+
+func TestRepositoryVersionsJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions RepositoryVersions
+		keys     []string
+	}{
+		{
+			name:     "default channels",
+			versions: RepositoryVersions{"release": {Name: "1.0.0"}, "beta": {Name: "1.1.0-beta"}},
+			keys:     []string{"Alpha", "Beta", "Release"},
+		},
+		{
+			name:     "custom channels",
+			versions: RepositoryVersions{"nightly": {Name: "1.1.0-nightly.2"}, "Nightly": {Name: "1.1.0-nightly.1"}, "release": {Name: "1.0.0"}},
+			keys:     []string{"Alpha", "Beta", "Nightly", "Release", "nightly"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := json.Marshal(test.versions)
+			if err != nil {
+				t.Fatalf("encoding: %v", err)
+			}
+
+			var raw map[string]Version
+			if err := json.Unmarshal(data, &raw); err != nil {
+				t.Fatalf("decoding %s: %v", data, err)
+			}
+			if len(raw) != len(test.keys) {
+				t.Errorf("got keys %s, want %v", data, test.keys)
+			}
+			for _, key := range test.keys {
+				if _, ok := raw[key]; !ok {
+					t.Errorf("missing key %s in %s", key, data)
+				}
+			}
+
+			var decoded RepositoryVersions
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("decoding %s: %v", data, err)
+			}
+			if len(decoded) != len(test.versions) {
+				t.Errorf("got %d channels back, want %d: %v", len(decoded), len(test.versions), decoded)
+			}
+			for channel, version := range test.versions {
+				if decoded[channel].Name != version.Name {
+					t.Errorf("got %s %q back, want %q", channel, decoded[channel].Name, version.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestChannelsHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		want   string
+	}{
+		{
+			name:   "JSON",
+			url:    "/acme/plugin/channels.json?pretty=false",
+			status: http.StatusOK,
+			want:   `{"Channels":[{"Name":"release","Version":"1.0.0","Tag":"v1.0.0","Date":1451747045000}]}`,
+		},
+		{
+			name:   "XML",
+			url:    "/acme/plugin/channels.xml?pretty=false",
+			status: http.StatusOK,
+			want:   `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<channels><channel name="release" version="1.0.0" tag="v1.0.0" date="1451747045000"></channel></channels>`,
+		},
+		{name: "unknown repository", url: "/acme/other/channels.json", status: http.StatusNotFound, want: "404 page not found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "v1.0.0", Date: 1451747045000, Url: "https://example.com/plugin-1.0.0.zip"},
+				"beta":    {},
+			}
+
+			w := serve(httptest.NewRequest("GET", test.url, nil))
+			if w.Code != test.status || strings.TrimSpace(w.Body.String()) != test.want {
+				t.Errorf("got status %d and %s, want %d and %s", w.Code, w.Body, test.status, test.want)
+			}
+		})
+	}
+}
+
+func TestChannelOrder(t *testing.T) {
+	const channels = `"channels": [{"name": "alpha", "pattern": "alpha"}, {"name": "nightly", "pattern": "nightly"}, {"name": "beta", "pattern": "beta"},
+		{"name": "canary", "pattern": "canary"}, {"name": "beta", "pattern": "rc"}, {"name": "release", "pattern": "."}]`
+	tests := []struct {
+		name  string
+		order string
+		want  []string
+	}{
+		{name: "default", want: []string{"release", "beta", "alpha", "nightly"}},
+		{name: "configured", order: `"channelOrder": ["nightly", "release"], `, want: []string{"nightly", "release", "alpha", "beta"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{`+test.order+`"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", `+channels+`}]}]}`)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"alpha":   {Name: "1.3.0-alpha", Tag: "1.3.0-alpha", Url: "https://example.com/plugin-1.3.0-alpha.zip"},
+				"nightly": {Name: "1.4.0-nightly", Tag: "1.4.0-nightly", Url: "https://example.com/plugin-1.4.0-nightly.zip"},
+				"beta":    {Name: "1.2.0-beta", Tag: "1.2.0-beta", Url: "https://example.com/plugin-1.2.0-beta.zip"},
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			}
+
+			for i := 0; i < 3; i++ {
+				var list struct {
+					Channels []struct{ Name string }
+				}
+				w := serve(httptest.NewRequest("GET", "/acme/plugin/channels.json", nil))
+				if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+					t.Fatalf("decoding %s: %v", w.Body, err)
+				}
+				var names []string
+				for _, channel := range list.Channels {
+					names = append(names, channel.Name)
+				}
+				if fmt.Sprint(names) != fmt.Sprint(test.want) {
+					t.Fatalf("got channels %v, want %v", names, test.want)
+				}
+			}
+
+			body := serve(httptest.NewRequest("GET", "/acme/plugin/plugins.xml", nil)).Body.String()
+			last := -1
+			for _, channel := range test.want {
+				version := repositories[0].Repositories[0].Versions[channel].Name
+				idx := strings.Index(body, `version="`+version+`"`)
+				if idx < last || strings.Count(body, `version="`+version+`"`) != 1 {
+					t.Errorf("plugins.xml doesn't list %s once and in order: %s", channel, body)
+				}
+				last = idx
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+//go:build appengine
+// +build appengine
+
+package wrigi
+
+import (
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+// newHTTPClient returns a urlfetch client. urlfetch ignores the request's
+// context, so its deadline is cut to what is left of the request's time.
+func newHTTPClient(r *http.Request) *http.Client {
+	deadline := fetchTimeout
+	if until, ok := requestContext(r).Deadline(); ok && time.Until(until) < deadline {
+		deadline = time.Until(until)
+	}
+	return &http.Client{
+		Transport: &urlfetch.Transport{
+			Context:  appengine.NewContext(r),
+			Deadline: deadline,
+		},
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"net/http"
+)
+
+func newHTTPClient(r *http.Request) *http.Client {
+	return &http.Client{
+		Transport: http.DefaultTransport,
+		Timeout:   fetchTimeout,
+	}
+}
@@ -0,0 +1,127 @@
+package wrigi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	gzipResponseWriter struct {
+		http.ResponseWriter
+		status int
+		buffer bytes.Buffer
+	}
+
+	compressedResponse struct {
+		sum  [sha1.Size]byte
+		body []byte
+	}
+)
+
+var (
+	compressionThreshold = 1024
+	compressedCache      = map[string]compressedResponse{}
+	compressedCacheLock  sync.Mutex
+)
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	return w.buffer.Write(data)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	accepted := false
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if name == "gzip" {
+			return quality > 0
+		}
+		accepted = quality > 0
+	}
+	return accepted
+}
+
+func gzipHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			handler(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		handler(gw, r)
+
+		if gw.buffer.Len() < compressionThreshold || w.Header().Get("Content-Encoding") != "" {
+			w.WriteHeader(gw.status)
+			w.Write(gw.buffer.Bytes())
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(gw.status)
+
+		gz := gzip.NewWriter(w)
+		gz.Write(gw.buffer.Bytes())
+		gz.Close()
+	}
+}
+
+func invalidateCompressedCache() {
+	compressedCacheLock.Lock()
+	compressedCache = map[string]compressedResponse{}
+	compressedCacheLock.Unlock()
+}
+
+func writeCompressed(w http.ResponseWriter, r *http.Request, key string, body []byte) {
+	if !acceptsGzip(r) || len(body) < compressionThreshold {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+		return
+	}
+
+	sum := sha1.Sum(body)
+
+	compressedCacheLock.Lock()
+	cached, ok := compressedCache[key]
+	compressedCacheLock.Unlock()
+
+	if !ok || cached.sum != sum {
+		var buffer bytes.Buffer
+		gz := gzip.NewWriter(&buffer)
+		gz.Write(body)
+		gz.Close()
+
+		cached = compressedResponse{sum: sum, body: buffer.Bytes()}
+		compressedCacheLock.Lock()
+		compressedCache[key] = cached
+		compressedCacheLock.Unlock()
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Length", strconv.Itoa(len(cached.body)))
+	w.Write(cached.body)
+}
@@ -0,0 +1,155 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipThreshold(t *testing.T) {
+	tests := []struct {
+		name           string
+		size           int
+		acceptEncoding string
+		gzipped        bool
+	}{
+		{name: "small", size: 63, acceptEncoding: "gzip", gzipped: false},
+		{name: "large", size: 64, acceptEncoding: "gzip", gzipped: true},
+		{name: "not accepted", size: 4096, acceptEncoding: "gzip;q=0, identity", gzipped: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"compressionThreshold": 64}`)
+			body := strings.Repeat("a", test.size)
+			handler := gzipHandler(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, body)
+			})
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if gzipped := w.Header().Get("Content-Encoding") == "gzip"; gzipped != test.gzipped {
+				t.Fatalf("got Content-Encoding %q, want gzipped=%v", w.Header().Get("Content-Encoding"), test.gzipped)
+			}
+			got := w.Body.String()
+			if test.gzipped {
+				got = gunzip(t, w.Body)
+			}
+			if got != body {
+				t.Errorf("got a %d byte body, want %d bytes", len(got), len(body))
+			}
+		})
+	}
+}
+
+func gunzip(t *testing.T, body io.Reader) string {
+	t.Helper()
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		t.Fatalf("reading the gzip stream: %v", err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("decompressing: %v", err)
+	}
+	return string(data)
+}
+
+func TestCompressedCacheReuse(t *testing.T) {
+	useConfig(t, `{"compressionThreshold": 0, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	repositories[0].Repositories[0].Versions = RepositoryVersions{
+		"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+	}
+	invalidateCompressedCache()
+
+	request := func() string {
+		r := httptest.NewRequest("GET", "/acme/plugin/release.xml", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := serve(r)
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("got Content-Encoding %q, want gzip", w.Header().Get("Content-Encoding"))
+		}
+		return w.Body.String()
+	}
+
+	first := request()
+	const key = "acme/plugin/release/xml"
+	compressedCacheLock.Lock()
+	cached := compressedCache[key]
+	if string(cached.body) != first {
+		t.Errorf("the cached body isn't the one served")
+	}
+	// Served again as long as the uncompressed body is the same.
+	cached.body = []byte("cached")
+	compressedCache[key] = cached
+	compressedCacheLock.Unlock()
+
+	if second := request(); second != "cached" {
+		t.Errorf("the second request compressed the body again")
+	}
+
+	repositories[0].Repositories[0].Versions["release"] = Version{Name: "1.0.1", Tag: "1.0.1", Url: "https://example.com/plugin-1.0.1.zip"}
+	if third := gunzip(t, strings.NewReader(request())); !strings.Contains(third, "<version>1.0.1</version>") {
+		t.Errorf("got %s, want the new version", third)
+	}
+}
+
+func TestGzipReadHandlers(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		gzipped        bool
+	}{
+		{name: "gzip", acceptEncoding: "gzip", gzipped: true},
+		{name: "among others", acceptEncoding: "deflate, br;q=0.9, gzip;q=0.5", gzipped: true},
+		{name: "upper case", acceptEncoding: "GZIP", gzipped: true},
+		{name: "wildcard", acceptEncoding: "*", gzipped: true},
+		{name: "refused", acceptEncoding: "gzip;q=0", gzipped: false},
+		{name: "refused before wildcard", acceptEncoding: "gzip;q=0, *", gzipped: false},
+		{name: "wildcard refused", acceptEncoding: "*;q=0", gzipped: false},
+		{name: "identity", acceptEncoding: "identity", gzipped: false},
+		{name: "none", gzipped: false},
+	}
+
+	for _, url := range []string{"/", "/acme/plugin/release.xml"} {
+		for _, test := range tests {
+			t.Run(url+" "+test.name, func(t *testing.T) {
+				useConfig(t, `{"compressionThreshold": 0, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+				repositories[0].Repositories[0].Versions = RepositoryVersions{
+					"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+				}
+				invalidateCompressedCache()
+
+				r := httptest.NewRequest("GET", url, nil)
+				if test.acceptEncoding != "" {
+					r.Header.Set("Accept-Encoding", test.acceptEncoding)
+				}
+				w := serve(r)
+
+				if gzipped := w.Header().Get("Content-Encoding") == "gzip"; gzipped != test.gzipped {
+					t.Fatalf("got Content-Encoding %q, want gzipped=%v", w.Header().Get("Content-Encoding"), test.gzipped)
+				}
+				body := w.Body.String()
+				if test.gzipped {
+					body = gunzip(t, w.Body)
+				}
+				if !strings.Contains(body, "1.0.0") {
+					t.Errorf("got %q, want the release", body)
+				}
+				if !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+					t.Errorf("got Vary %q, want Accept-Encoding", w.Header().Get("Vary"))
+				}
+			})
+		}
+	}
+}
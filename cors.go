@@ -0,0 +1,27 @@
+package wrigi
+
+import (
+	"net/http"
+)
+
+var CORSOrigin = "*"
+
+func corsHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if CORSOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", CORSOrigin)
+			if CORSOrigin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, If-None-Match, If-Modified-Since")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,97 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSReadRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		origin string
+		vary   bool
+	}{
+		{name: "default", config: `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`, origin: "*"},
+		{name: "configured", config: `{"corsOrigin": "https://dashboard.example.com", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`, origin: "https://dashboard.example.com", vary: true},
+	}
+	urls := []string{"/", "/stats", "/lastUpdate", "/acme/plugin/release.xml", "/acme/plugin/release", "/acme/plugin/channels.json", "/acme/plugin/feed.atom"}
+
+	for _, test := range tests {
+		for _, url := range urls {
+			t.Run(test.name+" "+url, func(t *testing.T) {
+				useConfig(t, test.config)
+				repositories[0].Repositories[0].Versions = RepositoryVersions{
+					"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+				}
+
+				w := serve(httptest.NewRequest("GET", url, nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("got status %d, want 200: %s", w.Code, w.Body)
+				}
+				if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.origin {
+					t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, test.origin)
+				}
+				if vary := varies(w.Header(), "Origin"); vary != test.vary {
+					t.Errorf("got Vary %q, want Origin %v", w.Header()["Vary"], test.vary)
+				}
+
+				r := httptest.NewRequest("OPTIONS", url, nil)
+				r.Header.Set("Origin", "https://dashboard.example.com")
+				r.Header.Set("Access-Control-Request-Method", "GET")
+				w = serve(r)
+				if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+					t.Fatalf("preflight: got status %d and %q, want an empty 204", w.Code, w.Body)
+				}
+				if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.origin {
+					t.Errorf("preflight: got Access-Control-Allow-Origin %q, want %q", got, test.origin)
+				}
+				if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, OPTIONS" {
+					t.Errorf("preflight: got Access-Control-Allow-Methods %q, want GET, OPTIONS", got)
+				}
+			})
+		}
+	}
+}
+
+func TestCORSAdminRoutes(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+	}{
+		{method: "GET", url: "/update"},
+		{method: "GET", url: "/cron/update"},
+		{method: "GET", url: "/retryErrors"},
+		{method: "POST", url: "/admin/reload"},
+		{method: "GET", url: "/acme/plugin/update"},
+		{method: "POST", url: "/acme/plugin/submitError"},
+		{method: "GET", url: "/acme/plugin/submitError"},
+		{method: "POST", url: "/webhook/github"},
+	}
+
+	for _, test := range tests {
+		for _, method := range []string{test.method, "OPTIONS"} {
+			t.Run(method+" "+test.url, func(t *testing.T) {
+				useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+				stubAPI(t, http.NotFound)
+
+				r := httptest.NewRequest(method, test.url, nil)
+				r.Header.Set("Origin", "https://dashboard.example.com")
+				w := serve(r)
+				if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+					t.Errorf("got Access-Control-Allow-Origin %q with status %d, want none", got, w.Code)
+				}
+				if w.Code == http.StatusNoContent {
+					t.Errorf("got a 204 preflight answer, want none")
+				}
+				if method != "POST" && test.url == "/acme/plugin/submitError" && w.Code != http.StatusMethodNotAllowed {
+					t.Errorf("got status %d, want 405", w.Code)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package wrigi
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type downloadCounter struct {
+	Owner      string
+	Repository string
+	Channel    string
+	Count      int64
+}
+
+func validMirror(raw string) error {
+	parsed, err := neturl.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("mirror %q must be an absolute http or https URL", raw)
+	}
+	return nil
+}
+
+func validateMirrors() {
+	for oidx, owner := range repositories {
+		for ridx, repository := range owner.Repositories {
+			if repository.MirrorBaseURL == "" {
+				continue
+			}
+			if err := validMirror(repository.MirrorBaseURL); err != nil {
+				fmt.Printf("Config error for %s/%s: %v, serving GitHub URLs\n", owner.Name, repository.Name, err)
+				repositories[oidx].Repositories[ridx].MirrorBaseURL = ""
+			}
+		}
+	}
+}
+
+// downloadURL points assetURL at MirrorBaseURL/<tag>/<file name> when a
+// mirror is configured.
+func (repository Repository) downloadURL(tag, assetURL string) string {
+	if repository.MirrorBaseURL == "" || assetURL == "" {
+		return assetURL
+	}
+	parsed, err := neturl.Parse(assetURL)
+	if err != nil {
+		return assetURL
+	}
+	return strings.TrimRight(repository.MirrorBaseURL, "/") + "/" + neturl.PathEscape(tag) + "/" + path.Base(parsed.Path)
+}
+
+func downloadCounterKey(owner, repository, channel string) *entityKey {
+	return newKey("DownloadCounter", repositoryKey(owner, repository)+"/"+channel, nil)
+}
+
+func pendingDownloadsKey(owner, repository, channel string) string {
+	return "downloads:" + repositoryKey(owner, repository) + "/" + channel
+}
+
+// incrementDownloads counts a download in memcache. flushDownloads moves
+// the pending counts to the datastore.
+func incrementDownloads(c appContext, owner, repository, channel string) error {
+	_, err := cache.Increment(c, pendingDownloadsKey(owner, repository, channel), 1, 0)
+	return err
+}
+
+// flushDownloads adds the downloads counted in memcache to the
+// DownloadCounter entities.
+func flushDownloads(c appContext) error {
+	var counters []downloadCounter
+	repositoriesLock.RLock()
+	for _, owner := range repositories {
+		for _, repository := range owner.Repositories {
+			var channels []string
+			for _, channel := range repository.channels() {
+				channels = append(channels, channel.Name)
+			}
+			if IncludeDrafts {
+				channels = append(channels, draftChannel)
+			}
+			for _, channel := range channels {
+				counters = append(counters, downloadCounter{Owner: owner.Name, Repository: repository.Name, Channel: channel})
+			}
+		}
+	}
+	repositoriesLock.RUnlock()
+
+	cacheKeys := make([]string, len(counters))
+	for idx, counter := range counters {
+		cacheKeys[idx] = pendingDownloadsKey(counter.Owner, counter.Repository, counter.Channel)
+	}
+	items, err := cache.GetMulti(c, cacheKeys)
+	if err != nil {
+		return err
+	}
+
+	var (
+		keys    []*entityKey
+		pending []downloadCounter
+	)
+	for idx, counter := range counters {
+		value, ok := items[cacheKeys[idx]]
+		if !ok {
+			continue
+		}
+		count, err := strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+		if err != nil || count <= 0 {
+			continue
+		}
+		// Take the count out of memcache before it is stored, so that
+		// downloads counted in the meantime stay pending.
+		if _, err := cache.Increment(c, cacheKeys[idx], -count, 0); err != nil {
+			return err
+		}
+		counter.Count = count
+		keys = append(keys, downloadCounterKey(counter.Owner, counter.Repository, counter.Channel))
+		pending = append(pending, counter)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	stored := make([]downloadCounter, len(keys))
+	err = store.GetMulti(c, keys, stored)
+	if errs, multi := err.(multiError); multi {
+		for _, err := range errs {
+			if err != nil && err != errNoSuchEntity {
+				return restoreDownloads(c, pending, err)
+			}
+		}
+	} else if err != nil {
+		return restoreDownloads(c, pending, err)
+	}
+	for idx := range stored {
+		stored[idx].Owner = pending[idx].Owner
+		stored[idx].Repository = pending[idx].Repository
+		stored[idx].Channel = pending[idx].Channel
+		stored[idx].Count += pending[idx].Count
+	}
+	if _, err := store.PutMulti(c, keys, stored); err != nil {
+		return restoreDownloads(c, pending, err)
+	}
+	return nil
+}
+
+// restoreDownloads puts counts that could not be stored back into memcache
+// for the next flush.
+func restoreDownloads(c appContext, pending []downloadCounter, err error) error {
+	for _, counter := range pending {
+		cache.Increment(c, pendingDownloadsKey(counter.Owner, counter.Repository, counter.Channel), counter.Count, 0)
+	}
+	return err
+}
+
+func downloadCounts(c appContext) (map[string]map[string]int64, error) {
+	var counters []downloadCounter
+	if _, err := store.GetAll(c, entityQuery{Kind: "DownloadCounter"}, &counters); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]map[string]int64{}
+	for _, counter := range counters {
+		key := repositoryKey(counter.Owner, counter.Repository)
+		if counts[key] == nil {
+			counts[key] = map[string]int64{}
+		}
+		counts[key][counter.Channel] = counter.Count
+	}
+	return counts, nil
+}
+
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	owner, channel := vars["owner"], vars["channel"]
+
+	repository, ok := findRepository(owner, vars["repository"])
+	if !ok || !repository.hasChannel(channel) || !repository.optedIn(r, channel) {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+	version := repository.Versions[channel]
+	if version.Name == "" || version.Url == "" {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	c := newContext(r)
+	if err := incrementDownloads(c, owner, repository.Name, channel); err != nil {
+		c.Warningf("counting download of %s/%s %s: %v", owner, repository.Name, channel, err)
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	http.Redirect(w, r, repository.downloadURL(version.Tag, version.Url), http.StatusFound)
+}
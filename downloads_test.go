@@ -0,0 +1,114 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestDownloadHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		url      string
+		status   int
+		location string
+	}{
+		{
+			name:     "release",
+			config:   pluginConfig,
+			url:      "/acme/plugin/release/download",
+			status:   http.StatusFound,
+			location: "https://github.com/acme/plugin/releases/download/v1.0.0/plugin.zip",
+		},
+		{
+			name:     "mirror",
+			config:   `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", "mirrorBaseURL": "https://mirror.example.com/plugin"}]}]}`,
+			url:      "/acme/plugin/release/download",
+			status:   http.StatusFound,
+			location: "https://mirror.example.com/plugin/v1.0.0/plugin.zip",
+		},
+		{
+			name:     "relative mirror",
+			config:   `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", "mirrorBaseURL": "mirror.example.com/plugin"}]}]}`,
+			url:      "/acme/plugin/release/download",
+			status:   http.StatusFound,
+			location: "https://github.com/acme/plugin/releases/download/v1.0.0/plugin.zip",
+		},
+		{
+			name:     "ftp mirror",
+			config:   `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", "mirrorBaseURL": "ftp://mirror.example.com/plugin"}]}]}`,
+			url:      "/acme/plugin/release/download",
+			status:   http.StatusFound,
+			location: "https://github.com/acme/plugin/releases/download/v1.0.0/plugin.zip",
+		},
+		{name: "empty channel", config: pluginConfig, url: "/acme/plugin/beta/download", status: http.StatusNotFound},
+		{name: "unknown channel", config: pluginConfig, url: "/acme/plugin/nightly/download", status: http.StatusNotFound},
+		{name: "unknown repository", config: pluginConfig, url: "/acme/other/release/download", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "v1.0.0", Url: "https://github.com/acme/plugin/releases/download/v1.0.0/plugin.zip"},
+			}
+
+			w := serve(httptest.NewRequest("GET", test.url, nil))
+			if w.Code != test.status {
+				t.Fatalf("got status %d, want %d", w.Code, test.status)
+			}
+			if location := w.Header().Get("Location"); location != test.location {
+				t.Errorf("got location %q, want %q", location, test.location)
+			}
+		})
+	}
+}
+
+func TestFlushDownloads(t *testing.T) {
+	useConfig(t, pluginConfig)
+	repositories[0].Repositories[0].Versions = RepositoryVersions{
+		"release": {Name: "1.0.0", Tag: "v1.0.0", Url: "https://example.com/plugin.zip"},
+		"beta":    {Name: "1.1.0-beta", Tag: "v1.1.0-beta", Url: "https://example.com/plugin-beta.zip"},
+	}
+	c := stdLogger{}
+
+	download := func(channel string, times int, wg *sync.WaitGroup) {
+		for idx := 0; idx < times; idx++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serve(httptest.NewRequest("GET", "/acme/plugin/"+channel+"/download", nil))
+			}()
+		}
+	}
+
+	// Downloads that happen during a flush stay pending for the next one.
+	var wg sync.WaitGroup
+	download("release", 20, &wg)
+	download("beta", 5, &wg)
+	if err := flushDownloads(c); err != nil {
+		t.Fatalf("flushing during the downloads: %v", err)
+	}
+	wg.Wait()
+	download("release", 10, &wg)
+	wg.Wait()
+	if err := flushDownloads(c); err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	counts, err := downloadCounts(c)
+	if err != nil {
+		t.Fatalf("counting: %v", err)
+	}
+	want := map[string]int64{"release": 30, "beta": 5, "alpha": 0}
+	for channel, count := range want {
+		if got := counts["acme/plugin"][channel]; got != count {
+			t.Errorf("got %d %s downloads, want %d", got, channel, count)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package wrigi
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type (
+	AtomLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+	}
+
+	AtomContent struct {
+		Type string `xml:"type,attr"`
+		Body string `xml:",chardata"`
+	}
+
+	AtomEntry struct {
+		Title   string      `xml:"title"`
+		ID      string      `xml:"id"`
+		Updated string      `xml:"updated"`
+		Link    AtomLink    `xml:"link"`
+		Content AtomContent `xml:"content"`
+	}
+
+	AtomFeed struct {
+		XMLName struct{}    `xml:"http://www.w3.org/2005/Atom feed"`
+		Title   string      `xml:"title"`
+		ID      string      `xml:"id"`
+		Updated string      `xml:"updated"`
+		Links   []AtomLink  `xml:"link"`
+		Entries []AtomEntry `xml:"entry"`
+	}
+)
+
+func atomTime(date int64) string {
+	return time.Unix(0, date*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
+
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	owner, repository, ok := lookupRepository(vars["owner"], vars["repository"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	homepage := repository.homepage(owner)
+	feed := AtomFeed{
+		Title: repository.PluginName + " releases",
+		ID:    homepage + "/releases",
+		Links: []AtomLink{
+			{Href: fmt.Sprintf("https://%s%s", r.Host, r.URL.Path), Rel: "self"},
+			{Href: homepage, Rel: "alternate"},
+		},
+	}
+
+	var updated int64
+	for _, channel := range repository.listedChannels(r) {
+		version := repository.Versions[channel]
+		if version.Date > updated {
+			updated = version.Date
+		}
+		feed.Entries = append(feed.Entries, AtomEntry{
+			Title:   version.Tag,
+			ID:      fmt.Sprintf("%s/releases/tag/%s#%s", homepage, version.Tag, channel),
+			Updated: atomTime(version.Date),
+			Link:    AtomLink{Href: repository.downloadURL(version.Tag, version.Url)},
+			Content: AtomContent{Type: "html", Body: string(changeNotes(version.Body))},
+		})
+	}
+	if updated == 0 {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	} else {
+		feed.Updated = atomTime(updated)
+	}
+
+	response, err := xml.MarshalIndent(feed, "", "    ")
+	if err != nil {
+		newContext(r).Errorf("encoding %s: %v", r.URL.Path, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml")
+	w.Write([]byte(xml.Header))
+	w.Write(response)
+}
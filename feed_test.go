@@ -0,0 +1,55 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFeedHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		links    map[string]string
+	}{
+		{
+			name:  "GitHub assets",
+			links: map[string]string{"v1.0.0": "https://github.com/acme/plugin/releases/download/v1.0.0/plugin.zip", "v1.1.0-beta": "https://github.com/acme/plugin/releases/download/v1.1.0-beta/plugin.zip"},
+		},
+		{
+			name:     "mirror",
+			settings: `, "mirrorBaseURL": "https://mirror.example.com/plugin/"`,
+			links:    map[string]string{"v1.0.0": "https://mirror.example.com/plugin/v1.0.0/plugin.zip", "v1.1.0-beta": "https://mirror.example.com/plugin/v1.1.0-beta/plugin.zip"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"`+test.settings+`}]}]}`)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "v1.0.0", Url: "https://github.com/acme/plugin/releases/download/v1.0.0/plugin.zip", Date: 1451747045000},
+				"beta":    {Name: "1.1.0-beta", Tag: "v1.1.0-beta", Url: "https://github.com/acme/plugin/releases/download/v1.1.0-beta/plugin.zip", Date: 1451833445000},
+			}
+
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/feed.atom", nil))
+			var feed AtomFeed
+			if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if len(feed.Entries) != len(test.links) {
+				t.Fatalf("got %d entries, want %d: %s", len(feed.Entries), len(test.links), w.Body)
+			}
+			for _, entry := range feed.Entries {
+				if want := test.links[entry.Title]; entry.Link.Href != want {
+					t.Errorf("got link %q for %s, want %q", entry.Link.Href, entry.Title, want)
+				}
+			}
+			if feed.Updated != "2016-01-03T15:04:05Z" {
+				t.Errorf("got updated %s, want the newest release", feed.Updated)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package wrigi
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type tokenRefresh struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+var (
+	GithubAppID             int64
+	GithubAppInstallationID int64
+	githubAppKey            *rsa.PrivateKey
+
+	appToken        string
+	appTokenExpires time.Time
+	appTokenRefresh *tokenRefresh
+	appTokenLock    sync.Mutex
+
+	errInvalidAppKey = errors.New("GitHub App private key is not an RSA key in PEM format")
+)
+
+func parseAppKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errInvalidAppKey
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errInvalidAppKey
+	}
+	return key, nil
+}
+
+func resetAppToken() {
+	appTokenLock.Lock()
+	appToken, appTokenExpires, appTokenRefresh = "", time.Time{}, nil
+	appTokenLock.Unlock()
+}
+
+func githubAppConfigured() bool {
+	return GithubAppID != 0 && GithubAppInstallationID != 0 && githubAppKey != nil
+}
+
+// appJWT signs the short lived RS256 token GitHub expects when an App
+// authenticates as itself.
+func appJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": GithubAppID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, githubAppKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func currentAppToken() string {
+	appTokenLock.Lock()
+	defer appTokenLock.Unlock()
+	return appToken
+}
+
+// installationToken returns the cached installation access token, exchanging
+// a fresh JWT for a new one when it is missing or about to expire. Only one
+// exchange runs at a time, and callers arriving meanwhile wait for it
+// without holding appTokenLock.
+func installationToken(client *http.Client) (string, error) {
+	appTokenLock.Lock()
+	now := time.Now()
+	if appToken != "" && now.Add(5*time.Minute).Before(appTokenExpires) {
+		token := appToken
+		appTokenLock.Unlock()
+		return token, nil
+	}
+	if call := appTokenRefresh; call != nil {
+		appTokenLock.Unlock()
+		<-call.done
+		return call.token, call.err
+	}
+	call := &tokenRefresh{done: make(chan struct{})}
+	appTokenRefresh = call
+	appTokenLock.Unlock()
+
+	token, expires, err := exchangeAppToken(client, now)
+
+	appTokenLock.Lock()
+	if appTokenRefresh == call {
+		if err == nil {
+			appToken, appTokenExpires = token, expires
+		}
+		appTokenRefresh = nil
+	}
+	appTokenLock.Unlock()
+
+	call.token, call.err = token, err
+	close(call.done)
+	return token, err
+}
+
+func exchangeAppToken(client *http.Client, now time.Time) (string, time.Time, error) {
+	jwt, err := appJWT(now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	url := fmt.Sprintf("https://api.github.com/app/installations/%d/access_tokens", GithubAppInstallationID)
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	request.Header.Set("User-Agent", UserAgent)
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("Authorization", "Bearer "+jwt)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return "", time.Time{}, statusError{url: url, status: response.StatusCode}
+	}
+
+	var token struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", time.Time{}, err
+	}
+	return token.Token, token.ExpiresAt, nil
+}
@@ -0,0 +1,133 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// verifyAppJWT checks the signature and issuer of a JWT signed with key.
+func verifyAppJWT(key *rsa.PrivateKey, jwt string, id int64) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%q isn't a JWT", jwt)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		Iss int64 `json:"iss"`
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return err
+	}
+	if claims.Iss != id || claims.Exp <= time.Now().Unix() {
+		return fmt.Errorf("got the claims %+v, want the issuer %d and a future expiry", claims, id)
+	}
+	return nil
+}
+
+func TestGithubAppToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+	tests := []struct {
+		name      string
+		config    string
+		want      string
+		exchanges int
+	}{
+		{
+			name:      "app",
+			config:    fmt.Sprintf(`"oauth": "static", "githubApp": {"id": 7, "installationId": 42, "privateKey": %q}, `, pemKey),
+			want:      "token ghs_installation",
+			exchanges: 1,
+		},
+		{name: "static token", config: `"oauth": "static", `, want: "token static"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("WRIGI_OAUTH_TOKEN", "")
+			t.Setenv("WRIGI_GITHUB_APP_KEY", "")
+			useConfig(t, `{"adminToken": "s3cret", `+test.config+`"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			t.Cleanup(resetAppToken)
+
+			var lock sync.Mutex
+			exchanges := 0
+			authorizations := map[string]string{}
+			var requests int32
+			releases := releasePages(&requests, []string{githubRelease("1.0.0", false)})
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+				if r.URL.Path == "/app/installations/42/access_tokens" {
+					exchanges++
+					if r.Method != "POST" {
+						t.Errorf("exchanged the token with %s, want POST", r.Method)
+					}
+					if err := verifyAppJWT(key, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), 7); err != nil {
+						t.Errorf("the exchange JWT: %v", err)
+					}
+					w.WriteHeader(http.StatusCreated)
+					fmt.Fprintf(w, `{"token": "ghs_installation", "expires_at": %q}`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+					return
+				}
+
+				authorizations[r.Method+" "+r.URL.Path] = r.Header.Get("Authorization")
+				switch r.URL.Path {
+				case "/search/issues":
+					fmt.Fprint(w, `{"items": []}`)
+				case "/repos/acme/plugin/issues":
+					w.WriteHeader(http.StatusCreated)
+					fmt.Fprint(w, `{"html_url": "https://github.com/acme/plugin/issues/1", "number": 1}`)
+				default:
+					releases(w, r)
+				}
+			})
+
+			r := httptest.NewRequest("GET", "/cron/update", nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			serve(r)
+			serve(httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(`{"body": "NullPointerException"}`)))
+
+			for _, request := range []string{"GET /repos/acme/plugin/releases", "GET /repos/acme/plugin", "GET /search/issues", "POST /repos/acme/plugin/issues"} {
+				if got := authorizations[request]; got != test.want {
+					t.Errorf("%s: got Authorization %q, want %q", request, got, test.want)
+				}
+			}
+			if exchanges != test.exchanges {
+				t.Errorf("got %d token exchanges, want %d", exchanges, test.exchanges)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package wrigi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+type (
+	GitlabReleaseLink struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+
+	GitlabReleaseSource struct {
+		Format string `json:"format"`
+		URL    string `json:"url"`
+	}
+
+	GitlabRelease struct {
+		Name            string `json:"name"`
+		TagName         string `json:"tag_name"`
+		Description     string `json:"description"`
+		ReleasedAt      string `json:"released_at"`
+		UpcomingRelease bool   `json:"upcoming_release"`
+		Assets          struct {
+			Sources []GitlabReleaseSource `json:"sources"`
+			Links   []GitlabReleaseLink   `json:"links"`
+		} `json:"assets"`
+	}
+)
+
+var GitlabToken string
+
+func gitlabReleasesURL(owner, repository string) string {
+	return fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/releases?per_page=100", neturl.QueryEscape(owner+"/"+repository))
+}
+
+func (repository Repository) releasesURL(owner string) string {
+	if repository.Source == "gitlab" {
+		return gitlabReleasesURL(owner, repository.Name)
+	}
+	return fmt.Sprintf("https://api.github.com/repos/%s/%s/releases?per_page=100", owner, repository.Name)
+}
+
+func (repository Repository) homepage(owner string) string {
+	if repository.Source == "gitlab" {
+		return fmt.Sprintf("https://gitlab.com/%s/%s", owner, repository.Name)
+	}
+	return fmt.Sprintf("https://github.com/%s/%s", owner, repository.Name)
+}
+
+// upcoming reports whether the release date is still in the future.
+func (release GitlabRelease) upcoming(now time.Time) bool {
+	if release.UpcomingRelease {
+		return true
+	}
+	released, err := parseTimestamp(release.ReleasedAt)
+	return err == nil && released.After(now)
+}
+
+// githubRelease converts the release so that it goes through the same
+// channel patterns as GitHub releases. GitLab has no prerelease flag.
+func (release GitlabRelease) githubRelease() GithubRelease {
+	converted := GithubRelease{
+		Body:        release.Description,
+		Name:        release.Name,
+		TagName:     release.TagName,
+		PublishedAt: release.ReleasedAt,
+	}
+	for _, source := range release.Assets.Sources {
+		switch source.Format {
+		case "zip":
+			converted.ZipballURL = source.URL
+		case "tar.gz":
+			converted.TarballURL = source.URL
+		}
+	}
+	for _, link := range release.Assets.Links {
+		converted.Assets = append(converted.Assets, GithubReleaseAsset{
+			Name: link.Name,
+			URL:  link.URL,
+		})
+	}
+	return converted
+}
+
+func fetchGitlabReleases(client *http.Client, log levelLogger, url, etag string) (releasePage, error) {
+	var page releasePage
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return page, err
+	}
+	request.Header.Set("User-Agent", UserAgent)
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	if GitlabToken != "" {
+		request.Header.Set("PRIVATE-TOKEN", GitlabToken)
+	}
+
+	log.Debugf("fetching %s", url)
+	response, err := fetchWithRetry(client, log, request)
+	if err != nil {
+		log.Debugf("fetching %s failed: %v", url, err)
+		return page, err
+	}
+	defer response.Body.Close()
+
+	log.Debugf("fetched %s with status %d", url, response.StatusCode)
+	if response.StatusCode == http.StatusNotModified {
+		page.notModified = true
+		return page, nil
+	}
+	if response.StatusCode != 200 {
+		return page, statusError{url: url, status: response.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return page, err
+	}
+
+	var releases []GitlabRelease
+	if err = json.Unmarshal(body, &releases); err != nil {
+		return page, err
+	}
+	now := time.Now()
+	for _, release := range releases {
+		if release.upcoming(now) {
+			log.Debugf("skipping release %s, it is due on %s", release.TagName, release.ReleasedAt)
+			page.upcoming = true
+			continue
+		}
+		page.releases = append(page.releases, release.githubRelease())
+	}
+
+	page.next = nextPage(response.Header.Get("Link"))
+	page.etag = response.Header.Get("ETag")
+	return page, nil
+}
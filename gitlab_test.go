@@ -0,0 +1,95 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitlabReleaseUpcoming(t *testing.T) {
+	now := time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		release GitlabRelease
+		want    bool
+	}{
+		{name: "released", release: GitlabRelease{ReleasedAt: "2016-01-01T00:00:00Z"}},
+		{name: "flagged upcoming", release: GitlabRelease{ReleasedAt: "2016-01-01T00:00:00Z", UpcomingRelease: true}, want: true},
+		{name: "dated in the future", release: GitlabRelease{ReleasedAt: "2016-02-01T00:00:00Z"}, want: true},
+		{name: "unparsable date", release: GitlabRelease{ReleasedAt: "soon"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.release.upcoming(now); got != test.want {
+				t.Errorf("got upcoming %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func gitlabRelease(tag, releasedAt string, upcoming bool) string {
+	return fmt.Sprintf(`{"name": %[1]q, "tag_name": %[1]q, "released_at": %[2]q, "upcoming_release": %[3]v,
+		"assets": {"links": [{"name": "plugin-%[1]s.zip", "url": "https://gitlab.com/acme/plugin/plugin-%[1]s.zip"}]}}`, tag, releasedAt, upcoming)
+}
+
+func TestUpdateGitlabRepository(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name     string
+		releases []string
+		release  string
+		etag     string
+	}{
+		{
+			name:     "released",
+			releases: []string{gitlabRelease("1.1.0", "2016-02-01T00:00:00Z", false), gitlabRelease("1.0.0", "2016-01-01T00:00:00Z", false)},
+			release:  "1.1.0",
+			etag:     `"v1"`,
+		},
+		{
+			name:     "flagged upcoming",
+			releases: []string{gitlabRelease("1.1.0", "2016-02-01T00:00:00Z", true), gitlabRelease("1.0.0", "2016-01-01T00:00:00Z", false)},
+			release:  "1.0.0",
+		},
+		{
+			name:     "dated in the future",
+			releases: []string{gitlabRelease("1.1.0", future, false), gitlabRelease("1.0.0", "2016-01-01T00:00:00Z", false)},
+			release:  "1.0.0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", "source": "gitlab"}]}]}`)
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasPrefix(r.URL.Path, "/api/v4/projects/") {
+					t.Errorf("unexpected request for %s", r.URL)
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				fmt.Fprintf(w, "[%s]", strings.Join(test.releases, ","))
+			})
+
+			repository, err := updateRepository(httptest.NewRequest("GET", "/update", nil), "acme", repositories[0].Repositories[0])
+			if err != nil {
+				t.Fatalf("updating: %v", err)
+			}
+			if got := repository.Versions["release"].Name; got != test.release {
+				t.Errorf("got release %q, want %q", got, test.release)
+			}
+			if repository.etag != test.etag {
+				t.Errorf("got etag %q, want %q", repository.etag, test.etag)
+			}
+		})
+	}
+}
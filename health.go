@@ -0,0 +1,79 @@
+package wrigi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type Health struct {
+	Loaded     bool
+	LastUpdate string `json:",omitempty"`
+}
+
+// lastSuccessfulUpdate returns the time of the most recent successful
+// fetch of any repository, whichever path refreshed it, and how many
+// repositories were fetched successfully at that time.
+func lastSuccessfulUpdate() (time.Time, int) {
+	var (
+		updated time.Time
+		count   int
+	)
+	repositoriesLock.RLock()
+	defer repositoriesLock.RUnlock()
+	for _, organization := range repositories {
+		for _, repository := range organization.Repositories {
+			switch {
+			case repository.lastSuccess.IsZero():
+			case repository.lastSuccess.After(updated):
+				updated, count = repository.lastSuccess, 1
+			case repository.lastSuccess.Equal(updated):
+				count++
+			}
+		}
+	}
+	return updated, count
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	updated, _ := lastSuccessfulUpdate()
+
+	health := Health{}
+	repositoriesLock.RLock()
+	for _, organization := range repositories {
+		if len(organization.Repositories) > 0 {
+			health.Loaded = true
+			break
+		}
+	}
+	repositoriesLock.RUnlock()
+	if !updated.IsZero() {
+		health.LastUpdate = updated.UTC().Format(time.RFC3339)
+	}
+
+	response, _ := json.Marshal(health)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if updated.IsZero() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(response)
+}
+
+type LastUpdate struct {
+	LastUpdate   string `json:",omitempty"`
+	Repositories int
+}
+
+func lastUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	updated, count := lastSuccessfulUpdate()
+	status := LastUpdate{Repositories: count}
+	if !updated.IsZero() {
+		status.LastUpdate = updated.UTC().Format(time.RFC3339)
+	}
+
+	response, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(response)
+}
@@ -0,0 +1,122 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stubReleases answers the release listing of every repository with a
+// single 1.0.0 release.
+func stubReleases(t *testing.T) {
+	t.Helper()
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "[%s]", githubRelease("1.0.0", false))
+	})
+}
+
+// storeRepository saves the repository as an earlier instance would have.
+func storeRepository(t *testing.T, owner string, repository Repository) {
+	t.Helper()
+	if err := saveRepositories(stdLogger{}, []ownedRepository{{owner: owner, repository: repository}}); err != nil {
+		t.Fatalf("saving %s/%s: %v", owner, repository.Name, err)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	fetched := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T)
+		status  int
+		updated string
+	}{
+		{
+			name:   "never updated",
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "after an update",
+			prepare: func(t *testing.T) {
+				stubReleases(t)
+				updateVersions(httptest.NewRequest("GET", "/update", nil), true)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "cold instance with stored data",
+			prepare: func(t *testing.T) {
+				storeRepository(t, "acme", Repository{Name: "plugin", Versions: RepositoryVersions{"release": {Name: "1.0.0"}}, lastFetch: fetched})
+			},
+			status:  http.StatusOK,
+			updated: fetched.UTC().Format(time.RFC3339),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			if test.prepare != nil {
+				test.prepare(t)
+			}
+
+			w := serve(httptest.NewRequest("GET", "/healthz", nil))
+			if w.Code != test.status {
+				t.Errorf("got status %d, want %d", w.Code, test.status)
+			}
+			var health Health
+			if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if !health.Loaded {
+				t.Error("got no repositories loaded")
+			}
+			if test.updated != "" && health.LastUpdate != test.updated {
+				t.Errorf("got last update %q, want %q", health.LastUpdate, test.updated)
+			}
+			if (test.status == http.StatusOK) != (health.LastUpdate != "") {
+				t.Errorf("got last update %q with status %d", health.LastUpdate, w.Code)
+			}
+		})
+	}
+}
+
+func TestLastUpdateHandler(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	stubReleases(t)
+	fetched := time.Now().Add(-time.Hour).Truncate(time.Second)
+	storeRepository(t, "acme", Repository{Name: "plugin", Versions: RepositoryVersions{"release": {Name: "1.0.0"}}, lastFetch: fetched})
+
+	lastUpdate := func() LastUpdate {
+		t.Helper()
+		w := serve(httptest.NewRequest("GET", "/lastUpdate", nil))
+		var status LastUpdate
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+		return status
+	}
+
+	if got, want := lastUpdate(), (LastUpdate{LastUpdate: fetched.UTC().Format(time.RFC3339), Repositories: 1}); got != want {
+		t.Errorf("got %+v from the store, want %+v", got, want)
+	}
+
+	r := httptest.NewRequest("GET", "/update", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("got status %d from /update: %s", w.Code, w.Body)
+	}
+
+	status := lastUpdate()
+	updated, err := time.Parse(time.RFC3339, status.LastUpdate)
+	if err != nil || !updated.After(fetched) || status.Repositories != 1 {
+		t.Errorf("got %+v after /update, want one repository updated after %s", status, fetched)
+	}
+}
@@ -0,0 +1,196 @@
+package wrigi
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type (
+	VersionTransition struct {
+		Channel  string    `xml:"channel,attr"`
+		OldTag   string    `xml:"from,attr"`
+		NewTag   string    `xml:"to,attr"`
+		Recorded time.Time `xml:"recorded,attr"`
+	}
+
+	repositoryTransitions struct {
+		owner       string
+		repository  string
+		transitions []VersionTransition
+	}
+
+	TransitionList struct {
+		Transitions []VersionTransition `xml:"transition"`
+		XMLName     struct{}            `xml:"history" json:"-"`
+	}
+)
+
+var (
+	historyBatchSize   = 100
+	historyConcurrency = 2
+	HistoryLimit       = 50
+)
+
+// inBatches calls write for consecutive ranges of at most historyBatchSize
+// of count items, running up to historyConcurrency of them at a time. It
+// returns the first error.
+func inBatches(count int, write func(start, end int) error) error {
+	var (
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, historyConcurrency)
+		errs = make(chan error, count/historyBatchSize+1)
+	)
+
+	for start := 0; start < count; start += historyBatchSize {
+		end := start + historyBatchSize
+		if end > count {
+			end = count
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := write(start, end); err != nil {
+				errs <- err
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func transitions(previous, current Repository, recorded time.Time) []VersionTransition {
+	var changed []VersionTransition
+	for channel, version := range current.Versions {
+		old := previous.Versions[channel]
+		if version.Name == "" || version.Name == old.Name {
+			continue
+		}
+		changed = append(changed, VersionTransition{
+			Channel:  channel,
+			OldTag:   old.Tag,
+			NewTag:   version.Tag,
+			Recorded: recorded,
+		})
+	}
+	return changed
+}
+
+func transitionLogKey(owner, repository string) *entityKey {
+	return newKey("TransitionLog", repositoryKey(owner, repository), nil)
+}
+
+// loadTransitions returns the recorded transitions, newest first. Sorting
+// happens here so the ancestor query needs no composite index.
+func loadTransitions(c appContext, owner, repository string) ([]*entityKey, []VersionTransition, error) {
+	var entries []VersionTransition
+	keys, err := store.GetAll(c, entityQuery{Kind: "Transition", Ancestor: transitionLogKey(owner, repository)}, &entries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	order := make([]int, len(entries))
+	for idx := range order {
+		order[idx] = idx
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return entries[order[i]].Recorded.After(entries[order[j]].Recorded)
+	})
+
+	sortedKeys := make([]*entityKey, len(order))
+	sortedEntries := make([]VersionTransition, len(order))
+	for idx, from := range order {
+		sortedKeys[idx], sortedEntries[idx] = keys[from], entries[from]
+	}
+	return sortedKeys, sortedEntries, nil
+}
+
+// writeTransitions stores the transitions of several repositories in
+// batches and then trims each repository's log to HistoryLimit entries.
+// Writing the same transitions again is harmless.
+func writeTransitions(c appContext, changed []repositoryTransitions) error {
+	var (
+		keys    []*entityKey
+		entries []VersionTransition
+		touched []repositoryTransitions
+	)
+	for _, entry := range changed {
+		if len(entry.transitions) == 0 {
+			continue
+		}
+		parent := transitionLogKey(entry.owner, entry.repository)
+		for _, transition := range entry.transitions {
+			// Keyed by what changed, so that a retried update writes the
+			// same entities again instead of adding more.
+			keys = append(keys, newKey("Transition", transition.Channel+"/"+transition.NewTag, parent))
+			entries = append(entries, transition)
+		}
+		touched = append(touched, entry)
+	}
+
+	err := inBatches(len(keys), func(start, end int) error {
+		_, err := store.PutMulti(c, keys[start:end], entries[start:end])
+		return err
+	})
+	if err != nil || HistoryLimit <= 0 {
+		return err
+	}
+
+	var stale []*entityKey
+	for _, entry := range touched {
+		existing, _, err := loadTransitions(c, entry.owner, entry.repository)
+		if err != nil {
+			return err
+		}
+		if len(existing) > HistoryLimit {
+			stale = append(stale, existing[HistoryLimit:]...)
+		}
+	}
+	return inBatches(len(stale), func(start, end int) error {
+		return store.DeleteMulti(c, stale[start:end])
+	})
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	repository, ok := findRepository(vars["owner"], vars["repository"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	c := newContext(r)
+	_, entries, err := loadTransitions(c, vars["owner"], repository.Name)
+	if err != nil {
+		c.Errorf("loading history of %s/%s: %v", vars["owner"], repository.Name, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	if HistoryLimit > 0 && len(entries) > HistoryLimit {
+		entries = entries[:HistoryLimit]
+	}
+
+	list := TransitionList{Transitions: entries}
+	if list.Transitions == nil {
+		list.Transitions = []VersionTransition{}
+	}
+
+	format := negotiateFormat(r, vars["format"])
+	response, err := marshalFormat(w, r, format, list)
+	if err != nil {
+		c.Errorf("encoding %s: %v", r.URL.Path, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
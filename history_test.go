@@ -0,0 +1,186 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts the batches put into the store, and fails the ones
+// listed in fail.
+type countingStore struct {
+	entityStore
+	lock    sync.Mutex
+	batches int
+	fail    map[int]bool
+}
+
+func (s *countingStore) PutMulti(c appContext, keys []*entityKey, src interface{}) ([]*entityKey, error) {
+	s.lock.Lock()
+	s.batches++
+	failed := s.fail[s.batches]
+	s.lock.Unlock()
+	if failed {
+		return nil, errors.New("batch failed")
+	}
+	return s.entityStore.PutMulti(c, keys, src)
+}
+
+// releaseTransitions returns count transitions of the release channel of
+// each of the repositories.
+func releaseTransitions(repositories, count int, recorded time.Time) []repositoryTransitions {
+	var changed []repositoryTransitions
+	for ridx := 0; ridx < repositories; ridx++ {
+		entry := repositoryTransitions{owner: "acme", repository: fmt.Sprintf("plugin%d", ridx)}
+		for idx := 0; idx < count; idx++ {
+			entry.transitions = append(entry.transitions, VersionTransition{
+				Channel:  "release",
+				OldTag:   fmt.Sprintf("v1.%d", idx),
+				NewTag:   fmt.Sprintf("v1.%d", idx+1),
+				Recorded: recorded.Add(time.Duration(idx) * time.Second),
+			})
+		}
+		changed = append(changed, entry)
+	}
+	return changed
+}
+
+func TestWriteTransitionsBatches(t *testing.T) {
+	tests := []struct {
+		name         string
+		repositories int
+		transitions  int
+		batchSize    int
+		batches      int
+	}{
+		{name: "single batch", repositories: 2, transitions: 3, batchSize: 10, batches: 1},
+		{name: "exact batches", repositories: 4, transitions: 5, batchSize: 10, batches: 2},
+		{name: "partial last batch", repositories: 3, transitions: 7, batchSize: 5, batches: 5},
+		{name: "one per batch", repositories: 3, transitions: 1, batchSize: 1, batches: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, fmt.Sprintf(`{"historyBatchSize": %d, "historyLimit": 0}`, test.batchSize))
+			counting := &countingStore{entityStore: store}
+			store = counting
+			changed := releaseTransitions(test.repositories, test.transitions, time.Now())
+
+			if err := writeTransitions(stdLogger{}, changed); err != nil {
+				t.Fatalf("writing: %v", err)
+			}
+			if counting.batches != test.batches {
+				t.Errorf("got %d batches, want %d", counting.batches, test.batches)
+			}
+
+			// A retried update writes the same history again.
+			if err := writeTransitions(stdLogger{}, changed); err != nil {
+				t.Fatalf("writing again: %v", err)
+			}
+			for ridx := 0; ridx < test.repositories; ridx++ {
+				_, entries, err := loadTransitions(stdLogger{}, "acme", fmt.Sprintf("plugin%d", ridx))
+				if err != nil {
+					t.Fatalf("loading: %v", err)
+				}
+				if len(entries) != test.transitions {
+					t.Errorf("got %d history points for plugin%d, want %d", len(entries), ridx, test.transitions)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	useConfig(t, pluginConfig)
+	var updates int32
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acme/plugin/releases" {
+			fmt.Fprint(w, `{"stargazers_count": 10}`)
+			return
+		}
+		fmt.Fprintf(w, "[%s]", githubRelease(fmt.Sprintf("v1.%d.0", atomic.LoadInt32(&updates)), false))
+	})
+
+	for idx := 0; idx < 3; idx++ {
+		// Every update sees a new release, and the cache would hide it.
+		atomic.StoreInt32(&updates, int32(idx))
+		cache = newMemoryCache()
+		updateVersions(httptest.NewRequest("GET", "/update", nil), true)
+		time.Sleep(time.Millisecond)
+	}
+
+	w := serve(httptest.NewRequest("GET", "/acme/plugin/history.json", nil))
+	var list TransitionList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	want := []VersionTransition{
+		{Channel: "release", OldTag: "v1.1.0", NewTag: "v1.2.0"},
+		{Channel: "release", OldTag: "v1.0.0", NewTag: "v1.1.0"},
+		{Channel: "release", NewTag: "v1.0.0"},
+	}
+	if len(list.Transitions) != len(want) {
+		t.Fatalf("got history %s, want %d entries", w.Body, len(want))
+	}
+	for idx, transition := range list.Transitions {
+		transition.Recorded = time.Time{}
+		if transition != want[idx] {
+			t.Errorf("got entry %d %+v, want %+v", idx, transition, want[idx])
+		}
+	}
+}
+
+func TestWriteTransitionsLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		fail    map[int]bool
+		entries int
+	}{
+		{name: "below the limit", limit: 10, entries: 7},
+		{name: "above the limit", limit: 3, entries: 3},
+		{name: "retried after a failed batch", limit: 10, fail: map[int]bool{2: true}, entries: 7},
+		{name: "retried above the limit", limit: 3, fail: map[int]bool{3: true}, entries: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, fmt.Sprintf(`{"historyBatchSize": 2, "historyConcurrency": 1, "historyLimit": %d}`, test.limit))
+			counting := &countingStore{entityStore: store, fail: test.fail}
+			store = counting
+			recorded := time.Now()
+			changed := releaseTransitions(1, 7, recorded)
+
+			err := writeTransitions(stdLogger{}, changed)
+			if (err != nil) != (test.fail != nil) {
+				t.Fatalf("got error %v, want a failure %v", err, test.fail != nil)
+			}
+			if err != nil {
+				counting.fail = nil
+				if err := writeTransitions(stdLogger{}, changed); err != nil {
+					t.Fatalf("retrying: %v", err)
+				}
+			}
+
+			_, entries, err := loadTransitions(stdLogger{}, "acme", "plugin0")
+			if err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if len(entries) != test.entries {
+				t.Fatalf("got %d history points, want %d", len(entries), test.entries)
+			}
+			if newest := entries[0].NewTag; newest != "v1.7" {
+				t.Errorf("got newest entry %s, want v1.7", newest)
+			}
+		})
+	}
+}
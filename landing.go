@@ -0,0 +1,88 @@
+package wrigi
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+type (
+	landingChannel struct {
+		Name    string
+		Version string
+		Url     string
+	}
+
+	landingPlugin struct {
+		Owner       string
+		Repository  string
+		PluginName  string
+		Description string
+		Channels    []landingChannel
+	}
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>Wrigi</title>
+</head>
+<body>
+    <h1>Wrigi</h1>
+    <p>Add one of the URLs below to <em>Settings | Plugins | Browse repositories... | Manage repositories...</em> in your IDE.</p>
+    {{range .}}
+    <h2>{{.PluginName}} <small>{{.Owner}}/{{.Repository}}</small></h2>
+    <p>{{.Description}}</p>
+    <table>
+        <tr><th>Channel</th><th>Version</th><th>Repository URL</th></tr>
+        {{range .Channels}}
+        <tr><td>{{.Name}}</td><td>{{if .Version}}{{.Version}}{{else}}n/a{{end}}</td><td><code>{{.Url}}</code></td></tr>
+        {{end}}
+    </table>
+    {{end}}
+</body>
+</html>
+`))
+
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func landingHandler(w http.ResponseWriter, r *http.Request) {
+	scheme := "https"
+	if isDevServer() {
+		scheme = "http"
+	}
+
+	var plugins []landingPlugin
+	repositoriesLock.RLock()
+	for _, owner := range repositories {
+		for _, repository := range owner.Repositories {
+			plugin := landingPlugin{
+				Owner:       owner.Name,
+				Repository:  repository.Name,
+				PluginName:  repository.PluginName,
+				Description: repository.Description,
+			}
+			for _, channel := range repository.channels() {
+				if !repository.optedIn(r, channel.Name) {
+					continue
+				}
+				plugin.Channels = append(plugin.Channels, landingChannel{
+					Name:    channel.Name,
+					Version: repository.Versions[channel.Name].Name,
+					Url:     fmt.Sprintf("%s://%s/%s/%s/%s.xml", scheme, r.Host, owner.Name, repository.Name, channel.Name),
+				})
+			}
+			plugins = append(plugins, plugin)
+		}
+	}
+	repositoriesLock.RUnlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := landingTemplate.Execute(w, plugins); err != nil {
+		newContext(r).Errorf("landing page: %v", err)
+	}
+}
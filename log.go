@@ -0,0 +1,76 @@
+package wrigi
+
+import (
+	"strings"
+)
+
+type (
+	logLevel int
+
+	levelLogger struct {
+		appContext
+		level logLevel
+	}
+)
+
+const (
+	debugLevel logLevel = iota
+	infoLevel
+	warningLevel
+	errorLevel
+)
+
+var (
+	globalLogLevel = infoLevel
+	DevPanics      = true
+)
+
+func devPanic(err error) {
+	if DevPanics && isDevServer() {
+		panic(err)
+	}
+}
+
+func parseLogLevel(name string) (logLevel, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return debugLevel, true
+	case "info":
+		return infoLevel, true
+	case "warning", "warn":
+		return warningLevel, true
+	case "error":
+		return errorLevel, true
+	}
+	return infoLevel, false
+}
+
+func (repository Repository) logger(c appContext) levelLogger {
+	level := globalLogLevel
+	if override, ok := parseLogLevel(repository.LogLevel); ok {
+		level = override
+	}
+	return levelLogger{appContext: c, level: level}
+}
+
+func (l levelLogger) unwrap() appContext {
+	return l.appContext
+}
+
+func (l levelLogger) Debugf(format string, args ...interface{}) {
+	if l.level <= debugLevel {
+		l.appContext.Debugf(format, args...)
+	}
+}
+
+func (l levelLogger) Infof(format string, args ...interface{}) {
+	if l.level <= infoLevel {
+		l.appContext.Infof(format, args...)
+	}
+}
+
+func (l levelLogger) Warningf(format string, args ...interface{}) {
+	if l.level <= warningLevel {
+		l.appContext.Warningf(format, args...)
+	}
+}
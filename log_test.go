@@ -0,0 +1,108 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRepositoryLogLevel(t *testing.T) {
+	tests := []struct {
+		name       string
+		global     string
+		repository string
+		want       []string
+	}{
+		{name: "global default", want: []string{"info", "warning", "error"}},
+		{name: "global warning", global: "warning", want: []string{"warning", "error"}},
+		{name: "repository debug", global: "warning", repository: "debug", want: []string{"debug", "info", "warning", "error"}},
+		{name: "repository error", repository: "error", want: []string{"error"}},
+		{name: "unknown repository level", global: "warning", repository: "loud", want: []string{"warning", "error"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"logLevel": "`+test.global+`"}`)
+			var logged []string
+			log := Repository{LogLevel: test.repository}.logger(recordingLogger{&logged})
+
+			log.Debugf("debug")
+			log.Infof("info")
+			log.Warningf("warning")
+			log.Errorf("error")
+
+			if strings.Join(logged, ",") != strings.Join(test.want, ",") {
+				t.Errorf("got %v logged, want %v", logged, test.want)
+			}
+		})
+	}
+}
+
+// captureLog collects what the standalone logger prints while the test runs.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buffer bytes.Buffer
+	log.SetOutput(&buffer)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buffer
+}
+
+func TestServerErrorsLogged(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		want   []string
+	}{
+		{
+			name:   "update",
+			method: "GET",
+			url:    "/cron/update",
+			want:   []string{"WARNING: ", "acme/plugin: server error 500", "ERROR: ", "update: all 1 repositories failed"},
+		},
+		{
+			name:   "submitError",
+			method: "POST",
+			url:    "/acme/plugin/submitError",
+			body:   `{"body": "NullPointerException"}`,
+			want:   []string{"submitting error to acme/plugin returned status 500"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"adminToken": "s3cret", "fetchAttempts": 1, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/search/issues" {
+					fmt.Fprint(w, `{"items": []}`)
+					return
+				}
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			})
+			attempts := submitErrorAttempts
+			submitErrorAttempts = 1
+			t.Cleanup(func() { submitErrorAttempts = attempts })
+			logged := captureLog(t)
+
+			r := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			r.Header.Set("Authorization", "Bearer s3cret")
+			serve(r)
+
+			output := logged.String()
+			for _, want := range test.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("the log is missing %q:\n%s", want, output)
+				}
+			}
+		})
+	}
+}
@@ -1,64 +1,156 @@
 package wrigi
 
 import (
-	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
-
-	"appengine"
-	"appengine/urlfetch"
 )
 
 type (
+	Asset struct {
+		Name          string
+		Url           string
+		Size          uint32
+		DownloadCount uint32
+		Digest        string `json:",omitempty"`
+	}
+
 	Version struct {
 		Name          string
+		Tag           string `json:",omitempty"`
 		Url           string
 		Size          uint32
 		Date          int64
 		Body          string
 		DownloadCount uint32
+		Digest        string  `json:",omitempty"`
+		Assets        []Asset `json:",omitempty"`
+		SizeHuman     string  `json:",omitempty"`
+	}
+
+	RepositoryVersions map[string]Version
+
+	Duration time.Duration
+
+	Secret string
+
+	Fallback struct {
+		Name       string
+		Url        string
+		SinceBuild string
 	}
 
-	RepositoryVersions struct {
-		Alpha   Version
-		Beta    Version
-		Release Version
+	ErrorTarget struct {
+		MinVersion string // inclusive
+		MaxVersion string // exclusive
+		Owner      string
+		Repository string
 	}
 
 	Repository struct {
-		Id          string
-		Name        string
-		PluginName  string
-		Description string
-		Versions    RepositoryVersions
-		Vendor      Vendor
+		Id             string
+		Name           string
+		PluginName     string
+		Description    string
+		Versions       RepositoryVersions
+		Channels       []ChannelPattern `json:",omitempty"`
+		IdeaVersion    *IdeaVersion     `json:",omitempty"`
+		Vendor         Vendor
+		Fallback       *Fallback     `json:",omitempty"`
+		UpdateInterval Duration      `json:",omitempty"`
+		ReleaseVersion int           `json:",omitempty"`
+		ErrorTargets   []ErrorTarget `json:",omitempty"`
+
+		StripChannelSuffix   bool   `json:",omitempty"`
+		ChannelSuffixPattern string `json:",omitempty"`
+
+		OptInChannels []string `json:",omitempty"`
+		OptInToken    Secret   `json:",omitempty"`
+
+		AssetPattern string            `json:",omitempty"`
+		AssetByOS    map[string]string `json:",omitempty"`
+		DefaultOS    string            `json:",omitempty"`
+
+		PrereleaseFallback bool `json:",omitempty"`
+
+		LogLevel string `json:",omitempty"`
+
+		Source string `json:",omitempty"`
+
+		Category string `json:",omitempty"`
+
+		MirrorBaseURL string `json:",omitempty"`
+
+		SourceArchives bool `json:",omitempty"`
+
+		ChannelIdeaVersions map[string]*IdeaVersion `json:",omitempty"`
+
+		TotalDownloads uint64
+		Rating         float32
+
+		lastFetch    time.Time
+		lastSuccess  time.Time
+		etag         string
+		lastStatus   int
+		assetPattern *regexp.Regexp
+		assetByOS    map[string]*regexp.Regexp
+		suffix       *regexp.Regexp
 	}
 
 	Organization struct {
 		Name         string
+		Vendor       *Vendor `json:",omitempty"`
 		Repositories []Repository
 	}
 
 	GithubReleaseAsset struct {
+		Name          string `json:"name"`
 		DownloadCount uint32 `json:"download_count"`
 		CreatedAt     string `json:"created_at"`
 		Size          uint32 `json:"size"`
 		URL           string `json:"browser_download_url"`
+		Digest        string `json:"digest"`
+	}
+
+	statusError struct {
+		url    string
+		status int
+	}
+
+	releasePage struct {
+		releases    []GithubRelease
+		next        string
+		etag        string
+		notModified bool
+		upcoming    bool
 	}
 
 	GithubRelease struct {
-		Body   string               `json:"body"`
-		Name   string               `json:"name"`
-		Assets []GithubReleaseAsset `json:"assets"`
+		Body        string               `json:"body"`
+		Name        string               `json:"name"`
+		TagName     string               `json:"tag_name"`
+		Prerelease  bool                 `json:"prerelease"`
+		Draft       bool                 `json:"draft"`
+		PublishedAt string               `json:"published_at"`
+		ZipballURL  string               `json:"zipball_url"`
+		TarballURL  string               `json:"tarball_url"`
+		Assets      []GithubReleaseAsset `json:"assets"`
 	}
 
 	Vendor struct {
@@ -68,25 +160,29 @@ type (
 	}
 
 	IdeaVersion struct {
-		Min        string `xml:"min,attr"`
-		Max        string `xml:"max,attr"`
+		Min        string `xml:"min,attr,omitempty" json:",omitempty"`
+		Max        string `xml:"max,attr,omitempty" json:",omitempty"`
 		SinceBuild string `xml:"since-build,attr"`
+		UntilBuild string `xml:"until-build,attr,omitempty" json:",omitempty"`
 	}
 
 	IdeaPlugin struct {
-		Downloads   uint32      `xml:"downloads,attr"`
-		Size        uint32      `xml:"size,attr"`
-		Date        int64       `xml:"date,attr"`
-		Url         string      `xml:"url,attr"`
-		Name        string      `xml:"name"`
-		ID          string      `xml:"id"`
-		Description string      `xml:"description"`
-		Version     string      `xml:"version"`
-		Vendor      Vendor      `xml:"vendor"`
-		IdeaVersion IdeaVersion `xml:"idea-version"`
-		ChangeNotes string      `xml:"change-notes,cdata"`
-		DownloadUrl string      `xml:"downloadUrl"`
-		Rating      float32     `xml:"rating"`
+		Downloads      uint32      `xml:"downloads,attr"`
+		Size           uint32      `xml:"size,attr"`
+		Date           int64       `xml:"date,attr"`
+		ReleaseDate    string      `xml:"release-date,attr,omitempty" json:",omitempty"`
+		ReleaseVersion string      `xml:"release-version,attr,omitempty" json:",omitempty"`
+		Checksum       string      `xml:"checksum,attr,omitempty" json:",omitempty"`
+		Url            string      `xml:"url,attr"`
+		Name           string      `xml:"name"`
+		ID             string      `xml:"id"`
+		Description    string      `xml:"description"`
+		Version        string      `xml:"version"`
+		Vendor         Vendor      `xml:"vendor"`
+		IdeaVersion    IdeaVersion `xml:"idea-version"`
+		ChangeNotes    CDATA       `xml:"change-notes"`
+		DownloadUrl    string      `xml:"downloadUrl"`
+		Rating         float32     `xml:"rating"`
 	}
 
 	PluginCategory struct {
@@ -94,6 +190,35 @@ type (
 		IdeaPlugin IdeaPlugin `xml:"idea-plugin"`
 	}
 
+	Envelope struct {
+		SchemaVersion int         `json:"schemaVersion"`
+		GeneratedAt   string      `json:"generatedAt"`
+		Data          interface{} `json:"data"`
+	}
+
+	RootFeed struct {
+		LastUpdate   string         `json:"lastUpdate,omitempty"`
+		Stale        bool           `json:"stale"`
+		Repositories []Organization `json:"repositories"`
+	}
+
+	RepositoryStatus struct {
+		Owner      string `json:"owner"`
+		Repository string `json:"repository"`
+		Stale      bool   `json:"stale"`
+		LastFetch  string `json:"lastFetch,omitempty"`
+
+		Updated bool              `json:"updated"`
+		Tags    map[string]string `json:"tags,omitempty"`
+		Error   string            `json:"error,omitempty"`
+	}
+
+	UpdateStatus struct {
+		Message      string             `json:"message"`
+		ResetAt      string             `json:"resetAt"`
+		Repositories []RepositoryStatus `json:"repositories"`
+	}
+
 	PluginRepository struct {
 		Ff       string         `xml:"ff"`
 		Category PluginCategory `xml:"category"`
@@ -102,38 +227,411 @@ type (
 )
 
 const (
-	userAgent string = "Wrigi 0.3 (https://github.com/dlsniper/wrigi)"
+	defaultUserAgent      string        = "Wrigi 0.3 (https://github.com/dlsniper/wrigi)"
+	defaultUpdateInterval time.Duration = 5 * time.Minute
+	jsonSchemaVersion     int           = 1
+	defaultChannelSuffix  string        = `(?i)[-_. ]*(alpha|beta|release)[-_.]?[0-9]*$`
+	defaultSinceBuild     string        = "139.1111"
+	defaultCategory       string        = "Custom Languages"
+)
+
+var (
+	fetchMaxAttempts = 3
+	fetchBackoffBase = 250 * time.Millisecond
+	fetchTimeout     = 30 * time.Second
+	fetchConcurrency = 4
+
+	versionNumber = regexp.MustCompile("[0-9]+")
+	channelSuffix = regexp.MustCompile(defaultChannelSuffix)
+	nextLink      = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+	timestampLayouts = []string{
+		time.RFC3339Nano,
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05 -0700",
+		"2006-01-02",
+	}
 )
 
 var (
-	repositories   []Organization
-	lastUpdate     time.Time
-	lastUpdateLock sync.Mutex
-	OAuthToken     string
+	repositories     []Organization
+	repositoriesLock sync.RWMutex
+	lastUpdateLock   sync.Mutex
+	updateInProgress bool
+	OAuthToken       string
+	UpdateSecret     string
+	JSONEnvelope     bool
+	UpdateInterval   = defaultUpdateInterval
+	UserAgent        = defaultUserAgent
+	RatingStars      = 1000
+	BinarySizes      bool
+
+	httpClientFactory = func(r *http.Request) *http.Client {
+		client := newHTTPClient(r)
+		client.Transport = contextTransport{ctx: requestContext(r), base: client.Transport}
+		return client
+	}
 )
 
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`""`), nil
+}
+
+func (repository Repository) updateInterval() time.Duration {
+	if repository.UpdateInterval > 0 {
+		return time.Duration(repository.UpdateInterval)
+	}
+	return UpdateInterval
+}
+
+func (repository Repository) category() string {
+	if repository.Category != "" {
+		return repository.Category
+	}
+	return defaultCategory
+}
+
+func (repository Repository) due(now time.Time) bool {
+	return now.Sub(repository.lastFetch) >= repository.updateInterval()
+}
+
+func compareVersions(a, b string) int {
+	left := versionNumber.FindAllString(a, -1)
+	right := versionNumber.FindAllString(b, -1)
+	for len(left) < len(right) {
+		left = append(left, "0")
+	}
+	for len(right) < len(left) {
+		right = append(right, "0")
+	}
+	for idx := range left {
+		l, _ := strconv.ParseUint(left[idx], 10, 64)
+		r, _ := strconv.ParseUint(right[idx], 10, 64)
+		if l < r {
+			return -1
+		}
+		if l > r {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (repository Repository) errorTarget(owner, name, pluginVersion string) (string, string) {
+	if pluginVersion == "" {
+		return owner, name
+	}
+	for _, target := range repository.ErrorTargets {
+		if target.MinVersion != "" && compareVersions(pluginVersion, target.MinVersion) < 0 {
+			continue
+		}
+		if target.MaxVersion != "" && compareVersions(pluginVersion, target.MaxVersion) >= 0 {
+			continue
+		}
+		return target.Owner, target.Repository
+	}
+	return owner, name
+}
+
+// configFile is read at startup and again by /admin/reload.
+var configFile = "./config.json"
+
 func initConfig() {
-	file, err := ioutil.ReadFile("./config.json")
+	file, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		fmt.Printf("File error: %v\n", err)
-		os.Exit(1)
+		fmt.Printf("File error: %v, using the default configuration\n", err)
+		file = []byte("{}")
+	}
+	// Serving the defaults instead would quietly drop the token and the
+	// configured repositories.
+	if err := applyConfig(file); err != nil {
+		panic(fmt.Sprintf("Config error: %v", err))
+	}
+}
+
+// restoreDefaults puts back the start-up value of every setting that
+// applyConfig only changes when it is configured, so that a reloaded
+// config doesn't keep settings that were removed from it.
+var restoreDefaults = captureDefaults()
+
+func captureDefaults() func() {
+	devPanics, userAgent, ratingStars, corsOrigin := DevPanics, UserAgent, RatingStars, CORSOrigin
+	threshold, logLevel := compressionThreshold, globalLogLevel
+	attempts, timeout, concurrency := fetchMaxAttempts, fetchTimeout, fetchConcurrency
+	submitLimit, submitWindow, submitMaxBody := SubmitErrorLimit, SubmitErrorWindow, SubmitErrorMaxBody
+	breakerThreshold, breakerCooldown := BreakerThreshold, BreakerCooldown
+	notesLength, channelOrder, requestTimeout, updateInterval := ChangeNotesMaxLength, ChannelOrder, RequestTimeout, UpdateInterval
+	batchSize, historyLimit, batchConcurrency := historyBatchSize, HistoryLimit, historyConcurrency
+
+	return func() {
+		DevPanics, UserAgent, RatingStars, CORSOrigin = devPanics, userAgent, ratingStars, corsOrigin
+		compressionThreshold, globalLogLevel = threshold, logLevel
+		fetchMaxAttempts, fetchTimeout, fetchConcurrency = attempts, timeout, concurrency
+		SubmitErrorLimit, SubmitErrorWindow, SubmitErrorMaxBody = submitLimit, submitWindow, submitMaxBody
+		BreakerThreshold, BreakerCooldown = breakerThreshold, breakerCooldown
+		ChangeNotesMaxLength, ChannelOrder, RequestTimeout, UpdateInterval = notesLength, channelOrder, requestTimeout, updateInterval
+		historyBatchSize, HistoryLimit, historyConcurrency = batchSize, historyLimit, batchConcurrency
 	}
+}
 
+func applyConfig(file []byte) error {
 	type CFG struct {
-		Oauth string
+		Oauth                string
+		OauthTokens          []string
+		UpdateSecret         string
+		JSONEnvelope         bool
+		CompressionThreshold *int
+		HistoryBatchSize     int
+		HistoryConcurrency   int
+		HistoryLimit         *int
+		QueueFailedReports   bool
+		FetchAttempts        int
+		FetchTimeout         Duration
+		FetchConcurrency     int
+		UpdateInterval       Duration `json:"update_interval"`
+		WebhookSecret        string
+		AdminToken           string
+		DevPanics            *bool
+		GitlabToken          string
+		CORSOrigin           *string
+		RatingStars          int
+		UserAgent            *string
+		SubmitErrorLimit     *int
+		SubmitErrorWindow    Duration
+		SubmitErrorMaxBody   int64
+		RequestTimeout       *Duration
+		ChannelOrder         []string
+		IncludeDrafts        bool `json:"include_drafts"`
+		ChangeNotesMaxLength *int
+		BreakerThreshold     *int
+		RobotsAllowRoot      bool
+		BinarySizes          bool
+		BreakerCooldown      Duration
+		LogLevel             string
+		Organizations        []Organization
+		Repositories         map[string]json.RawMessage
+
+		GithubApp struct {
+			ID             int64
+			InstallationID int64
+			PrivateKey     string
+		}
 	}
 
 	var cfg CFG
-	json.Unmarshal(file, &cfg)
+	if err := json.Unmarshal(file, &cfg); err != nil {
+		return err
+	}
+	for _, owner := range cfg.Organizations {
+		for ridx := range owner.Repositories {
+			if err := owner.Repositories[ridx].compilePatterns(); err != nil {
+				return fmt.Errorf("%s/%s: %v", owner.Name, owner.Repositories[ridx].Name, err)
+			}
+		}
+	}
+	restoreDefaults()
 	OAuthToken = cfg.Oauth
+	if OAuthToken == "" {
+		OAuthToken = os.Getenv("WRIGI_OAUTH_TOKEN")
+	}
+	OAuthTokens = nil
+	for _, token := range append([]string{OAuthToken}, cfg.OauthTokens...) {
+		if token = strings.TrimSpace(token); token != "" {
+			OAuthTokens = append(OAuthTokens, token)
+		}
+	}
+	if OAuthToken == "" && len(OAuthTokens) > 0 {
+		OAuthToken = OAuthTokens[0]
+	}
+	GithubAppID = cfg.GithubApp.ID
+	GithubAppInstallationID = cfg.GithubApp.InstallationID
+	githubAppKey = nil
+	resetAppToken()
+	appKey := cfg.GithubApp.PrivateKey
+	if appKey == "" {
+		appKey = os.Getenv("WRIGI_GITHUB_APP_KEY")
+	}
+	if appKey != "" {
+		if key, err := parseAppKey(appKey); err == nil {
+			githubAppKey = key
+		} else {
+			fmt.Printf("Config error: GitHub App private key: %v\n", err)
+		}
+	}
+	UpdateSecret = cfg.UpdateSecret
+	WebhookSecret = cfg.WebhookSecret
+	AdminToken = cfg.AdminToken
+	if cfg.DevPanics != nil {
+		DevPanics = *cfg.DevPanics
+	}
+	GitlabToken = cfg.GitlabToken
+	if cfg.UserAgent != nil {
+		if agent := strings.TrimSpace(*cfg.UserAgent); agent != "" {
+			UserAgent = agent
+		} else {
+			fmt.Printf("Config error: userAgent must not be empty, using %q\n", UserAgent)
+		}
+	}
+	if cfg.RatingStars > 0 {
+		RatingStars = cfg.RatingStars
+	}
+	if cfg.CORSOrigin != nil {
+		CORSOrigin = *cfg.CORSOrigin
+	}
+	JSONEnvelope = cfg.JSONEnvelope
+	IncludeDrafts = cfg.IncludeDrafts
+	RobotsAllowRoot = cfg.RobotsAllowRoot
+	BinarySizes = cfg.BinarySizes
+	QueueFailedReports = cfg.QueueFailedReports
+	if cfg.CompressionThreshold != nil {
+		compressionThreshold = *cfg.CompressionThreshold
+	}
+	if level, ok := parseLogLevel(cfg.LogLevel); ok {
+		globalLogLevel = level
+	} else if cfg.LogLevel != "" {
+		fmt.Printf("Config error: unknown log level %q\n", cfg.LogLevel)
+	}
+	if cfg.FetchAttempts > 0 {
+		fetchMaxAttempts = cfg.FetchAttempts
+	}
+	if cfg.FetchTimeout > 0 {
+		fetchTimeout = time.Duration(cfg.FetchTimeout)
+	}
+	if cfg.FetchConcurrency > 0 {
+		fetchConcurrency = cfg.FetchConcurrency
+	}
+	if cfg.SubmitErrorLimit != nil {
+		SubmitErrorLimit = *cfg.SubmitErrorLimit
+	}
+	if cfg.BreakerThreshold != nil {
+		BreakerThreshold = *cfg.BreakerThreshold
+	}
+	if cfg.BreakerCooldown > 0 {
+		BreakerCooldown = time.Duration(cfg.BreakerCooldown)
+	}
+	if cfg.ChangeNotesMaxLength != nil {
+		ChangeNotesMaxLength = *cfg.ChangeNotesMaxLength
+	}
+	if len(cfg.ChannelOrder) > 0 {
+		ChannelOrder = cfg.ChannelOrder
+	}
+	if cfg.RequestTimeout != nil {
+		RequestTimeout = time.Duration(*cfg.RequestTimeout)
+	}
+	if cfg.SubmitErrorMaxBody > 0 {
+		SubmitErrorMaxBody = cfg.SubmitErrorMaxBody
+	}
+	if cfg.SubmitErrorWindow > 0 {
+		SubmitErrorWindow = time.Duration(cfg.SubmitErrorWindow)
+	}
+	if cfg.UpdateInterval > 0 {
+		UpdateInterval = time.Duration(cfg.UpdateInterval)
+	}
+	if cfg.HistoryBatchSize > 0 {
+		historyBatchSize = cfg.HistoryBatchSize
+	}
+	if cfg.HistoryLimit != nil {
+		HistoryLimit = *cfg.HistoryLimit
+	}
+	if cfg.HistoryConcurrency > 0 {
+		historyConcurrency = cfg.HistoryConcurrency
+	}
 
-	initSupportedRepositories()
+	initSupportedRepositories(cfg.Organizations)
+	applyRepositorySettings(cfg.Repositories)
+	validateMirrors()
+	return nil
+}
+
+func repositoryKey(owner, repository string) string {
+	return strings.ToLower(strings.TrimSpace(owner)) + "/" + strings.ToLower(strings.TrimSpace(repository))
+}
+
+func findRepository(owner, name string) (Repository, bool) {
+	repositoriesLock.RLock()
+	defer repositoriesLock.RUnlock()
+
+	key := repositoryKey(owner, name)
+	for _, org := range repositories {
+		for _, repository := range org.Repositories {
+			if repositoryKey(org.Name, repository.Name) == key {
+				return repository, true
+			}
+		}
+	}
+	return Repository{}, false
+}
+
+func applyRepositorySettings(settings map[string]json.RawMessage) {
+	normalized := make(map[string]json.RawMessage, len(settings))
+	for key, raw := range settings {
+		parts := strings.SplitN(key, "/", 2)
+		if len(parts) != 2 {
+			fmt.Printf("Config error: invalid repository key %q\n", key)
+			continue
+		}
+		normalized[repositoryKey(parts[0], parts[1])] = raw
+	}
+
+	for oidx, owner := range repositories {
+		for ridx, repository := range owner.Repositories {
+			raw, ok := normalized[repositoryKey(owner.Name, repository.Name)]
+			if !ok {
+				continue
+			}
+			var settings Repository
+			err := json.Unmarshal(raw, &settings)
+			if err == nil {
+				err = settings.compilePatterns()
+			}
+			if err != nil {
+				fmt.Printf("Config error for %s/%s: %v, ignoring its settings\n", owner.Name, repository.Name, err)
+				continue
+			}
+			json.Unmarshal(raw, &repositories[oidx].Repositories[ridx])
+			repositories[oidx].Repositories[ridx].compilePatterns()
+		}
+	}
 }
-func initSupportedRepositories() {
+
+func initSupportedRepositories(organizations []Organization) {
+	if len(organizations) > 0 {
+		for oidx, owner := range organizations {
+			if owner.Vendor == nil {
+				continue
+			}
+			for ridx, repository := range owner.Repositories {
+				if repository.Vendor == (Vendor{}) {
+					organizations[oidx].Repositories[ridx].Vendor = *owner.Vendor
+				}
+			}
+		}
+		repositories = organizations
+		return
+	}
+
 	organization := Organization{
 		Name: "go-lang-plugin-org",
 	}
-	repositories = append(repositories, organization)
+	repositories = []Organization{organization}
 	repository := Repository{
 		Id:          "ro.redeul.google.go",
 		Name:        "go-lang-idea-plugin",
@@ -148,274 +646,1326 @@ func initSupportedRepositories() {
 	repositories[0].Repositories = append(repositories[0].Repositories, repository)
 }
 
-func updateRepository(r *http.Request, owner string, repository Repository) Repository {
-	var (
-		client    *http.Client
-		body      []byte
-		ghRelease []GithubRelease
-	)
+func updateRepository(r *http.Request, owner string, repository Repository) (Repository, error) {
+	var client *http.Client
 
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/releases", owner, repository.Name)
+	url := repository.releasesURL(owner)
+	fetch := fetchReleases
+	if repository.Source == "gitlab" {
+		fetch = fetchGitlabReleases
+	}
 
-	r.Header.Set("User-Agent", userAgent)
-	c := appengine.NewContext(r)
-	client = urlfetch.Client(c)
+	c := newContext(r)
+	client = httpClientFactory(r)
+	log := repository.logger(c)
 
-	response, err := client.Get(url)
-	if err != nil {
-		if appengine.IsDevAppServer() {
-			panic(err)
-		}
-		return repository
+	if versions, ok := cachedVersions(c, owner, repository.Name); ok {
+		log.Debugf("%s/%s: using versions from memcache", owner, repository.Name)
+		repository.Versions = versions
+		repository.TotalDownloads = repository.totalDownloads()
+		return repository, nil
 	}
 
-	if err != nil || response.StatusCode != 200 {
-		if appengine.IsDevAppServer() {
-			panic(err)
-		}
-		return repository
+	if repository.Source != "gitlab" && rateLimited() {
+		log.Debugf("%s/%s: skipping fetch while rate limited", owner, repository.Name)
+		atomic.AddUint64(&rateLimitHits, 1)
+		return lastKnownGood(c, owner, repository), errRateLimited
 	}
 
-	body, err = ioutil.ReadAll(response.Body)
-	defer response.Body.Close()
-
-	if err = json.Unmarshal(body, &ghRelease); err != nil {
-		if appengine.IsDevAppServer() {
-			panic(err)
-		}
-		return repository
+	host := hostOf(url)
+	if !breakerAllow(host, time.Now()) {
+		log.Debugf("%s/%s: skipping fetch while the circuit for %s is open", owner, repository.Name, host)
+		return lastKnownGood(c, owner, repository), errCircuitOpen
 	}
 
-	repository.Versions.Alpha = Version{}
-	repository.Versions.Beta = Version{}
-	repository.Versions.Release = Version{}
+	updated := repository
+	updated.Versions = RepositoryVersions{}
 
-	relType := regexp.MustCompile("alpha|beta|release")
-
-	for _, release := range ghRelease {
+	etag := repository.etag
+	untagged := 0
+	for first := true; url != ""; first = false {
+		if err := requestContext(r).Err(); err != nil {
+			log.Warningf("%s/%s: giving up on releases: %v", owner, repository.Name, err)
+			atomic.AddUint64(&fetchFailures, 1)
+			return lastKnownGood(c, owner, repository), err
+		}
+		page, err := fetch(client, log, url, etag)
+		if err != nil {
+			atomic.AddUint64(&fetchFailures, 1)
+			status := 0
+			if statusErr, ok := err.(statusError); ok {
+				status = statusErr.status
+			}
+			breakerRecord(host, status != 0 && status < 500 && !isTimeout(err), time.Now())
+			switch {
+			case isTimeout(err):
+				log.Warningf("%s/%s: fetching releases timed out, keeping existing data: %v", owner, repository.Name, err)
+			case status == http.StatusNotFound:
+				log.Errorf("%s/%s: repository not found, check the configured owner and name", owner, repository.Name)
+			case status == http.StatusUnauthorized:
+				log.Errorf("%s/%s: credentials rejected, check the configured OAuth token", owner, repository.Name)
+			case status == http.StatusForbidden || status == http.StatusTooManyRequests:
+				log.Warningf("%s/%s: access denied or rate limited (status %d), keeping existing data", owner, repository.Name, status)
+				atomic.AddUint64(&rateLimitHits, 1)
+			case status >= 500:
+				log.Warningf("%s/%s: server error %d, retrying on the next update", owner, repository.Name, status)
+			default:
+				log.Errorf("%s/%s: fetching releases failed: %v", owner, repository.Name, err)
+			}
+			repository = lastKnownGood(c, owner, repository)
+			repository.lastStatus = status
+			return repository, err
+		}
 
-		fmt.Printf("%#v<br/>\n", release)
+		breakerRecord(host, true, time.Now())
 
-		relDate, err := time.Parse("2006-01-02T15:04:05Z", release.Assets[0].CreatedAt)
-		relD := time.Now().UTC().Unix()
-		if err == nil {
-			relD = relDate.Unix()
+		if page.notModified {
+			log.Debugf("%s/%s: releases not modified", owner, repository.Name)
+			atomic.AddUint64(&fetchSuccesses, 1)
+			cacheVersions(c, owner, repository)
+			repository.lastStatus = http.StatusNotModified
+			return repository, nil
 		}
-		relD = relD * 1000
-
-		rel := Version{
-			Name:          release.Name,
-			DownloadCount: release.Assets[0].DownloadCount,
-			Url:           release.Assets[0].URL,
-			Size:          release.Assets[0].Size,
-			Date:          relD,
-			Body:          release.Body,
+		if first {
+			updated.etag = page.etag
+			etag = ""
 		}
 
-		if relType.FindString(release.Name) == "alpha" && repository.Versions.Alpha.Name == "" {
-			repository.Versions.Alpha = rel
+		if page.upcoming {
+			// The releases won't change once an upcoming release is
+			// out, so they have to be fetched again, not revalidated.
+			updated.etag = ""
 		}
 
-		if relType.FindString(release.Name) == "beta" && repository.Versions.Beta.Name == "" {
-			repository.Versions.Beta = rel
-		}
+		untagged += classifyReleases(log, owner, &updated, page.releases)
 
-		if relType.FindString(release.Name) == "release" && repository.Versions.Release.Name == "" {
-			repository.Versions.Release = rel
+		if updated.channelsFilled() {
+			break
 		}
+		url = page.next
 	}
 
-	return repository
-}
-
-func updateVersions(r *http.Request) {
-	for oidx, owner := range repositories {
-		for ridx, repository := range owner.Repositories {
-			repositories[oidx].Repositories[ridx] = updateRepository(r, owner.Name, repository)
+	if repository.Source != "gitlab" {
+		if stars, err := fetchStars(client, log, owner, repository.Name); err == nil {
+			updated.Rating = starRating(stars)
+		} else {
+			log.Warningf("%s/%s: fetching stargazers failed: %v", owner, repository.Name, err)
 		}
 	}
-}
 
-func rootHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	response, err := json.Marshal(repositories)
-	if err != nil {
-		w.Write([]byte(fmt.Sprintf("%s", err)))
+	if len(updated.Versions) == 0 && untagged > 0 {
+		log.Warningf("%s/%s: every release lacks a tag name, keeping existing data", owner, repository.Name)
+		updated.Versions = repository.Versions
 	}
-	w.Write(response)
+
+	updated.TotalDownloads = updated.totalDownloads()
+	updated.lastStatus = http.StatusOK
+	atomic.AddUint64(&fetchSuccesses, 1)
+	cacheVersions(c, owner, updated)
+	return updated, nil
 }
 
-func updateHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain")
+func fetchStars(client *http.Client, log levelLogger, owner, repository string) (int, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s", owner, repository)
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("User-Agent", UserAgent)
+	authorize(client, request)
 
-	lastUpdateLock.Lock()
+	response, err := fetchWithRetry(client, log, request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	recordRateLimit(request, response.Header)
+	if response.StatusCode != 200 {
+		return 0, statusError{url: url, status: response.StatusCode}
+	}
 
-	if time.Since(lastUpdate) < 5*time.Minute {
-		w.Write([]byte("Repositories where updated less than 5 minutes ago. Please come back later."))
-		lastUpdateLock.Unlock()
-		return
+	var info struct {
+		Stargazers int `json:"stargazers_count"`
 	}
+	err = json.NewDecoder(response.Body).Decode(&info)
+	return info.Stargazers, err
+}
 
-	updateVersions(r)
+func starRating(stars int) float32 {
+	if stars <= 0 || RatingStars <= 0 {
+		return 0
+	}
+	rating := 5 * math.Log10(float64(stars)+1) / math.Log10(float64(RatingStars)+1)
+	if rating > 5 {
+		rating = 5
+	}
+	return float32(math.Floor(rating*10+0.5) / 10)
+}
 
-	lastUpdateLock.Unlock()
+func (err statusError) Error() string {
+	return fmt.Sprintf("fetching %s returned status %d", err.url, err.status)
+}
 
-	w.Write([]byte("Remote repositories updated"))
+func nextPage(link string) string {
+	if match := nextLink.FindStringSubmatch(link); match != nil {
+		return match[1]
+	}
+	return ""
 }
 
-func tokenHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte(OAuthToken))
+func isTimeout(err error) bool {
+	if urlErr, ok := err.(*neturl.Error); ok {
+		err = urlErr.Err
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return platformTimeout(err)
 }
 
-func submitErrorHandler(w http.ResponseWriter, r *http.Request) {
-	var client *http.Client
-	vars := mux.Vars(r)
+func fetchWithRetry(client *http.Client, log levelLogger, request *http.Request) (*http.Response, error) {
+	backoff := fetchBackoffBase
+	for attempt := 1; ; attempt++ {
+		response, err := client.Do(request)
+		if err == nil && response.StatusCode < 500 {
+			return response, nil
+		}
+		if attempt >= fetchMaxAttempts {
+			return response, err
+		}
 
-	w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			log.Debugf("attempt %d for %s failed: %v", attempt, request.URL, err)
+		} else {
+			log.Debugf("attempt %d for %s returned status %d", attempt, request.URL, response.StatusCode)
+			response.Body.Close()
+		}
+		if err := sleep(client, backoff); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
 
-	r.Header.Set("User-Agent", r.Header.Get("User-Agent")+" "+userAgent)
+func fetchReleases(client *http.Client, log levelLogger, url, etag string) (releasePage, error) {
+	var page releasePage
 
-	c := appengine.NewContext(r)
-	client = urlfetch.Client(c)
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return page, err
+	}
+	request.Header.Set("User-Agent", UserAgent)
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	authorize(client, request)
 
-	body, err := ioutil.ReadAll(r.Body)
+	log.Debugf("fetching %s", url)
+	response, err := fetchWithRetry(client, log, request)
 	if err != nil {
-		w.WriteHeader(500)
-		if appengine.IsDevAppServer() {
-			c.Errorf("%+v", err)
-			panic(err)
-		}
-		return
+		log.Debugf("fetching %s failed: %v", url, err)
+		return page, err
 	}
+	defer response.Body.Close()
 
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues?access_token=%s", vars["owner"], vars["repository"], OAuthToken)
+	log.Debugf("fetched %s with status %d", url, response.StatusCode)
+	if status, ok := recordRateLimit(request, response.Header); ok && status.Limited {
+		log.Warningf("GitHub token rate limit exhausted until %s", status.Reset.UTC().Format(time.RFC3339))
+	}
+	if response.StatusCode == http.StatusNotModified {
+		page.notModified = true
+		return page, nil
+	}
+	if response.StatusCode != 200 {
+		return page, statusError{url: url, status: response.StatusCode}
+	}
 
-	response, err := client.Post(url, "application/json", bytes.NewBuffer(body))
+	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		w.WriteHeader(500)
-		if appengine.IsDevAppServer() {
-			c.Errorf("%+v", err)
-			panic(err)
-		}
-		return
+		return page, err
 	}
 
-	body, err = ioutil.ReadAll(response.Body)
-	if err != nil {
-		w.WriteHeader(500)
-		if appengine.IsDevAppServer() {
-			c.Errorf("%+v", err)
-			panic(err)
-		}
-		return
+	if err = json.Unmarshal(body, &page.releases); err != nil {
+		return page, err
 	}
 
-	w.WriteHeader(response.StatusCode)
-	w.Write(body)
+	page.next = nextPage(response.Header.Get("Link"))
+	page.etag = response.Header.Get("ETag")
+	return page, nil
 }
 
-func ideaPluginHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
+// classifyReleases sorts ghRelease into the channels of repository and
+// returns how many releases were skipped for lacking a tag name.
+func classifyReleases(log levelLogger, owner string, repository *Repository, ghRelease []GithubRelease) (untagged int) {
+	for _, release := range ghRelease {
+		log.Debugf("%s/%s: considering release %q (tag %q)", owner, repository.Name, release.Name, release.TagName)
 
-	var repository Repository
-	for _, owner := range repositories {
-		if owner.Name == vars["owner"] {
-			for _, repo := range owner.Repositories {
-				if repo.Name == vars["repository"] {
-					repository = repo
-					break
-				}
+		if release.TagName == "" {
+			log.Warningf("skipping release %q of %s/%s without a tag name", release.Name, owner, repository.Name)
+			untagged++
+			continue
+		}
+
+		channel := ""
+		if release.Draft {
+			if !IncludeDrafts {
+				log.Debugf("%s/%s: skipping draft release %s", owner, repository.Name, release.TagName)
+				continue
 			}
-			break
+			channel = draftChannel
 		}
-	}
 
-	if repository.Name == "" {
-		http.Error(w, "404 page not found", 404)
-		return
-	}
+		var asset GithubReleaseAsset
+		switch {
+		case len(release.Assets) > 0:
+			asset = repository.selectAsset(release.Assets)
+		case repository.SourceArchives && release.ZipballURL != "":
+			asset = GithubReleaseAsset{URL: release.ZipballURL}
+		case repository.SourceArchives && release.TarballURL != "":
+			asset = GithubReleaseAsset{URL: release.TarballURL}
+		default:
+			log.Warningf("skipping release %s of %s/%s without any downloadable asset", release.TagName, owner, repository.Name)
+			continue
+		}
+		if len(release.Assets) == 0 {
+			log.Warningf("%s/%s: release %s has no assets, advertising the source archive %s", owner, repository.Name, release.TagName, asset.URL)
+		}
+		log.Debugf("%s/%s: release %s uses asset %s", owner, repository.Name, release.TagName, asset.URL)
 
-	var version Version
+		published := release.PublishedAt
+		if published == "" {
+			published = asset.CreatedAt
+		}
+		relDate, err := parseTimestamp(published)
+		if err != nil {
+			log.Warningf("%s/%s: release %s has an unparsable date %q, using the current time: %v", owner, repository.Name, release.TagName, published, err)
+			relDate = time.Now()
+		}
+		relD := relDate.UTC().Unix() * 1000
 
-	switch vars["channel"] {
-	case "alpha":
-		version = repository.Versions.Alpha
-	case "beta":
-		version = repository.Versions.Beta
-	case "release":
-		version = repository.Versions.Release
-	default:
-		{
-			http.Error(w, "404 page not found", 404)
-			return
+		name := release.Name
+		if strings.TrimSpace(name) == "" {
+			name = release.TagName
 		}
-	}
 
-	ideaPlugin := IdeaPlugin{
-		Name:        repository.PluginName,
-		ID:          repository.Id + "." + vars["channel"],
-		Description: repository.Description,
-		Version:     version.Name,
-		Size:        version.Size,
-		Date:        version.Date,
-		Url:         fmt.Sprintf("https://github.com/%s/%s", vars["owner"], vars["repository"]),
-		DownloadUrl: version.Url,
-		Downloads:   version.DownloadCount,
-		ChangeNotes: version.Body,
-		Vendor:      repository.Vendor,
-		IdeaVersion: IdeaVersion{
-			Min:        "n/a",
-			Max:        "n/a",
-			SinceBuild: "139.1111",
-		},
-	}
+		rel := Version{
+			Name:          name,
+			Tag:           release.TagName,
+			DownloadCount: asset.DownloadCount,
+			Url:           asset.URL,
+			Size:          asset.Size,
+			Date:          relD,
+			Body:          release.Body,
+			Digest:        asset.Digest,
+		}
+		for _, asset := range release.Assets {
+			rel.Assets = append(rel.Assets, Asset{
+				Name:          asset.Name,
+				Url:           asset.URL,
+				Size:          asset.Size,
+				DownloadCount: asset.DownloadCount,
+				Digest:        asset.Digest,
+			})
+		}
 
-	pluginCategory := PluginCategory{
-		Name:       "Custom Languages",
-		IdeaPlugin: ideaPlugin,
+		if channel == "" {
+			channel = repository.releaseChannel(release)
+		}
+		if channel == "" {
+			log.Debugf("%s/%s: release %s matches no channel", owner, repository.Name, release.TagName)
+			continue
+		}
+
+		if newerVersion(rel, repository.Versions[channel]) {
+			log.Debugf("%s/%s: %s channel set to %s (%s)", owner, repository.Name, channel, rel.Name, rel.Url)
+			repository.Versions[channel] = rel
+		}
 	}
+	return untagged
+}
 
-	plugin := PluginRepository{
-		Ff:       "\"Custom Languages\"",
-		Category: pluginCategory,
+func (repository Repository) selectAsset(assets []GithubReleaseAsset) GithubReleaseAsset {
+	if repository.assetPattern != nil {
+		for _, asset := range assets {
+			if repository.assetPattern.MatchString(asset.Name) {
+				return asset
+			}
+		}
+	}
+
+	for _, asset := range assets {
+		if strings.HasSuffix(asset.Name, ".zip") || strings.HasSuffix(asset.Name, ".jar") {
+			return asset
+		}
+	}
+
+	return assets[0]
+}
+
+func repositoriesDue(now time.Time) bool {
+	repositoriesLock.RLock()
+	defer repositoriesLock.RUnlock()
+
+	for _, owner := range repositories {
+		for _, repository := range owner.Repositories {
+			if repository.due(now) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func nextUpdate() time.Time {
+	repositoriesLock.RLock()
+	defer repositoriesLock.RUnlock()
+
+	var next time.Time
+	for _, owner := range repositories {
+		for _, repository := range owner.Repositories {
+			due := repository.lastFetch.Add(repository.updateInterval())
+			if next.IsZero() || due.Before(next) {
+				next = due
+			}
+		}
+	}
+	return next
+}
+
+func updateStatus(now time.Time, message string, resetAt time.Time) UpdateStatus {
+	status := UpdateStatus{
+		Message:      message,
+		ResetAt:      resetAt.UTC().Format(time.RFC3339),
+		Repositories: []RepositoryStatus{},
+	}
+
+	repositoriesLock.RLock()
+	defer repositoriesLock.RUnlock()
+	for _, owner := range repositories {
+		for _, repository := range owner.Repositories {
+			repositoryStatus := RepositoryStatus{
+				Owner:      owner.Name,
+				Repository: repository.Name,
+				Stale:      repository.due(now),
+			}
+			if !repository.lastFetch.IsZero() {
+				repositoryStatus.LastFetch = repository.lastFetch.UTC().Format(time.RFC3339)
+			}
+			status.Repositories = append(status.Repositories, repositoryStatus)
+		}
+	}
+	return status
+}
+
+func fetchStatus(owner string, repository Repository, err error) RepositoryStatus {
+	status := RepositoryStatus{
+		Owner:      owner,
+		Repository: repository.Name,
+		LastFetch:  repository.lastFetch.UTC().Format(time.RFC3339),
+		Updated:    err == nil,
+		Tags:       map[string]string{},
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	for _, channel := range repository.channels() {
+		if version := repository.Versions[channel.Name]; version.Tag != "" {
+			status.Tags[channel.Name] = version.Tag
+		}
+	}
+	return status
+}
+
+func updateVersions(r *http.Request, force bool) []RepositoryStatus {
+	var (
+		changes  []repositoryTransitions
+		updated  []ownedRepository
+		statuses = []RepositoryStatus{}
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, fetchConcurrency)
+	)
+
+	now := time.Now()
+	repositoriesLock.RLock()
+	for _, owner := range repositories {
+		for _, repository := range owner.Repositories {
+			lock.Lock()
+			statuses = append(statuses, RepositoryStatus{
+				Owner:      owner.Name,
+				Repository: repository.Name,
+			})
+			sidx := len(statuses) - 1
+			lock.Unlock()
+			if !force && !repository.due(now) {
+				continue
+			}
+
+			wg.Add(1)
+			go func(sidx int, owner string, repository Repository) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				if force {
+					// A forced update asks GitHub, not the versions cached in memcache.
+					cache.Delete(newContext(r), versionsCacheKey(owner, repository.Name))
+				}
+				previous := repository
+				repository, err := updateRepository(r, owner, repository)
+				repository.lastFetch = now
+				if err == nil {
+					repository.lastSuccess = now
+				}
+				status := fetchStatus(owner, repository, err)
+
+				storeFetched(owner, repository)
+
+				lock.Lock()
+				statuses[sidx] = status
+				if err == nil {
+					changes = append(changes, repositoryTransitions{
+						owner:       owner,
+						repository:  repository.Name,
+						transitions: transitions(previous, repository, now),
+					})
+					updated = append(updated, ownedRepository{owner: owner, repository: repository})
+				}
+				lock.Unlock()
+			}(sidx, owner.Name, repository)
+		}
+	}
+	repositoriesLock.RUnlock()
+	wg.Wait()
+
+	invalidateCompressedCache()
+
+	c := newContext(r)
+	if err := saveRepositories(c, updated); err != nil {
+		c.Errorf("saving repositories: %v", err)
+	}
+	if err := writeTransitions(c, changes); err != nil {
+		c.Errorf("transitions: %v", err)
+	}
+	if err := flushDownloads(c); err != nil {
+		c.Errorf("download counters: %v", err)
+	}
+	return statuses
+}
+
+func wrapJSON(r *http.Request, data interface{}) interface{} {
+	wrap := JSONEnvelope
+	if value := r.FormValue("envelope"); value != "" {
+		wrap, _ = strconv.ParseBool(value)
+	}
+	if !wrap {
+		return data
+	}
+	return Envelope{
+		SchemaVersion: jsonSchemaVersion,
+		GeneratedAt:   time.Now().UTC().Format(time.RFC3339),
+		Data:          data,
+	}
+}
+
+func rootFeed(now, updated time.Time, organizations []Organization) RootFeed {
+	feed := RootFeed{
+		Stale:        updated.IsZero() || now.Sub(updated) > UpdateInterval,
+		Repositories: organizations,
+	}
+	if !updated.IsZero() {
+		feed.LastUpdate = updated.UTC().Format(time.RFC3339)
+	}
+	return feed
+}
+
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if wantsHTML(r) {
+		landingHandler(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	var (
+		response []byte
+		err      error
+	)
+	updated, _ := lastSuccessfulUpdate()
+
+	repositoriesLock.RLock()
+	feed := []Organization{}
+	owner := strings.ToLower(strings.TrimSpace(r.FormValue("owner")))
+	for _, org := range repositories {
+		if owner != "" && strings.ToLower(org.Name) != owner {
+			continue
+		}
+		published := org
+		published.Repositories = make([]Repository, len(org.Repositories))
+		for idx, repository := range org.Repositories {
+			published.Repositories[idx] = repository.published(r)
+		}
+		feed = append(feed, published)
+	}
+	var data interface{} = feed
+	if raw, _ := strconv.ParseBool(r.FormValue("raw")); !raw {
+		data = rootFeed(time.Now(), updated, feed)
+	}
+	if prettyOutput(r, false) {
+		response, err = json.MarshalIndent(wrapJSON(r, data), "", "    ")
+	} else {
+		response, err = json.Marshal(wrapJSON(r, data))
+	}
+	repositoriesLock.RUnlock()
+	if err != nil {
+		newContext(r).Errorf("encoding %s: %v", r.URL.Path, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"internal server error"}`))
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(response)))
+	w.Write(response)
+}
+
+func updateHandler(w http.ResponseWriter, r *http.Request) {
+	runUpdate(w, r, false)
+}
+
+func cronUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	runUpdate(w, r, true)
+}
+
+func runUpdate(w http.ResponseWriter, r *http.Request, force bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	lastUpdateLock.Lock()
+
+	now := time.Now()
+	var status *UpdateStatus
+	if updateInProgress {
+		status = &UpdateStatus{
+			Message:      "An update is already in progress. Please come back later.",
+			Repositories: []RepositoryStatus{},
+		}
+	} else if limit := RateLimitStatus(); limit.Limited {
+		current := updateStatus(now, "GitHub rate limit exceeded. Please come back later.", limit.Reset)
+		status = &current
+	} else if !force && !repositoriesDue(now) {
+		current := updateStatus(now, "Repositories where updated too recently. Please come back later.", nextUpdate())
+		status = &current
+	}
+
+	if status != nil {
+		lastUpdateLock.Unlock()
+
+		response, _ := json.Marshal(status)
+		w.Write(response)
+		return
+	}
+
+	updateInProgress = true
+	lastUpdateLock.Unlock()
+
+	statuses := updateVersions(r, force)
+	finishUpdate(r)
+
+	// The update fails as a whole only when no fetched repository got
+	// through, so that cron and monitoring see the outage. Partial
+	// failures are listed per repository.
+	fetched, failed := 0, 0
+	for _, status := range statuses {
+		if status.Updated || status.Error != "" {
+			fetched++
+		}
+		if status.Error != "" {
+			failed++
+		}
+	}
+	message := "Remote repositories updated"
+	if fetched > 0 && failed == fetched {
+		message = "Updating the remote repositories failed"
+		newContext(r).Errorf("update: all %d repositories failed", failed)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	response, _ := json.Marshal(UpdateStatus{
+		Message:      message,
+		ResetAt:      nextUpdate().UTC().Format(time.RFC3339),
+		Repositories: statuses,
+	})
+	w.Write(response)
+}
+
+// lookupRepository returns the configured owner name and the repository
+// matching owner and name.
+func lookupRepository(owner, name string) (string, Repository, bool) {
+	repositoriesLock.RLock()
+	defer repositoriesLock.RUnlock()
+
+	key := repositoryKey(owner, name)
+	for _, org := range repositories {
+		for _, repository := range org.Repositories {
+			if repositoryKey(org.Name, repository.Name) == key {
+				return org.Name, repository, true
+			}
+		}
+	}
+	return "", Repository{}, false
+}
+
+// setFetched copies the state fetched from GitHub or the datastore, and
+// nothing that comes from the config.
+func (repository *Repository) setFetched(from Repository) {
+	repository.Versions = from.Versions
+	repository.TotalDownloads = from.TotalDownloads
+	repository.Rating = from.Rating
+	repository.lastFetch = from.lastFetch
+	repository.lastSuccess = from.lastSuccess
+	repository.etag = from.etag
+	repository.lastStatus = from.lastStatus
+}
+
+// storeFetched writes the fetched state back to the repository with the
+// same owner and name, if it is still configured.
+func storeFetched(owner string, fetched Repository) {
+	repositoriesLock.Lock()
+	defer repositoriesLock.Unlock()
+
+	key := repositoryKey(owner, fetched.Name)
+	for oidx, org := range repositories {
+		for ridx, repository := range org.Repositories {
+			if repositoryKey(org.Name, repository.Name) == key {
+				repositories[oidx].Repositories[ridx].setFetched(fetched)
+				return
+			}
+		}
+	}
+}
+
+func refreshRepository(r *http.Request, owner string, repository Repository, now time.Time) (Repository, error) {
+	previous := repository
+	repository, err := updateRepository(r, owner, repository)
+	repository.lastFetch = now
+	if err == nil {
+		repository.lastSuccess = now
+	}
+	storeFetched(owner, repository)
+
+	invalidateCompressedCache()
+
+	if err != nil {
+		return repository, err
+	}
+
+	c := newContext(r)
+	if err := saveRepositories(c, []ownedRepository{{owner: owner, repository: repository}}); err != nil {
+		c.Errorf("saving repositories: %v", err)
+	}
+	changed := []repositoryTransitions{{owner: owner, repository: repository.Name, transitions: transitions(previous, repository, now)}}
+	if err := writeTransitions(c, changed); err != nil {
+		c.Errorf("transitions of %s/%s: %v", owner, repository.Name, err)
+	}
+	return repository, nil
+}
+
+func repositoryUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	owner, repository, ok := lookupRepository(vars["owner"], vars["repository"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	lastUpdateLock.Lock()
+
+	now := time.Now()
+	var status *UpdateStatus
+	if updateInProgress {
+		status = &UpdateStatus{
+			Message:      "An update is already in progress. Please come back later.",
+			Repositories: []RepositoryStatus{},
+		}
+	} else if limit := RateLimitStatus(); limit.Limited {
+		status = &UpdateStatus{
+			Message:      "GitHub rate limit exceeded. Please come back later.",
+			ResetAt:      limit.Reset.UTC().Format(time.RFC3339),
+			Repositories: []RepositoryStatus{},
+		}
+	} else if !repository.due(now) {
+		status = &UpdateStatus{
+			Message:      "Repository was updated too recently. Please come back later.",
+			ResetAt:      repository.lastFetch.Add(repository.updateInterval()).UTC().Format(time.RFC3339),
+			Repositories: []RepositoryStatus{},
+		}
+	}
+
+	if status != nil {
+		lastUpdateLock.Unlock()
+
+		response, _ := json.Marshal(status)
+		w.Write(response)
+		return
+	}
+
+	updateInProgress = true
+	lastUpdateLock.Unlock()
+
+	repository, err := refreshRepository(r, owner, repository, now)
+	finishUpdate(r)
+
+	message := "Remote repository updated"
+	if err != nil {
+		message = "Updating the remote repository failed"
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	response, _ := json.Marshal(UpdateStatus{
+		Message:      message,
+		ResetAt:      repository.lastFetch.Add(repository.updateInterval()).UTC().Format(time.RFC3339),
+		Repositories: []RepositoryStatus{fetchStatus(owner, repository, err)},
+	})
+	w.Write(response)
+}
+
+func overridesAllowed(r *http.Request) bool {
+	if isDevServer() {
+		return true
+	}
+	secret := r.Header.Get("X-Wrigi-Secret")
+	return UpdateSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(UpdateSecret)) == 1
+}
+
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(OAuthToken))
+}
+
+func submitErrorHandler(w http.ResponseWriter, r *http.Request) {
+	var client *http.Client
+	vars := mux.Vars(r)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	c := newContext(r)
+	client = httpClientFactory(r)
+
+	if ip := clientIP(r); submitRateLimited(c, ip) {
+		c.Warningf("rate limiting error reports from %s", ip)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":"too many requests"}`))
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, SubmitErrorMaxBody+1))
+	if err != nil {
+		w.WriteHeader(500)
+		c.Errorf("reading error report: %v", err)
+		devPanic(err)
+		return
+	}
+	if int64(len(body)) > SubmitErrorMaxBody {
+		result, _ := json.Marshal(map[string]string{"error": errReportTooLarge.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(result)
+		return
+	}
+
+	owner, repository, ok := lookupRepository(vars["owner"], vars["repository"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+	name := repository.Name
+
+	report, err := parseReport(body)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"error": err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(result)
+		return
+	}
+	owner, name = repository.errorTarget(owner, name, report.PluginVersion)
+
+	body, err = report.issue()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	if number, ok := findDuplicate(client, owner, name, report.Fingerprint); ok {
+		response, err := commentIssue(client, owner, name, number, body)
+		if err == nil {
+			response.Body.Close()
+		}
+		if err == nil && response.StatusCode == http.StatusCreated {
+			c.Infof("report for %s/%s matched issue #%d", owner, name, number)
+			result, _ := json.Marshal(struct {
+				URL       string `json:"url"`
+				Number    int    `json:"number"`
+				Duplicate bool   `json:"duplicate"`
+			}{fmt.Sprintf("https://github.com/%s/%s/issues/%d", owner, name, number), number, true})
+			w.Write(result)
+			return
+		}
+		c.Warningf("commenting on %s/%s#%d failed, opening a new issue", owner, name, number)
+	}
+
+	response, err := postIssue(client, issuesURL(owner, name), body)
+	if QueueFailedReports && (err != nil || retryableStatus(response.StatusCode)) {
+		if err == nil {
+			response.Body.Close()
+		}
+		if err = queueReport(c, owner, name, body); err == nil {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"queued":true}`))
+			return
+		}
+		c.Errorf("queue report: %v", err)
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		c.Errorf("submitting error to %s/%s: %v", owner, name, err)
+		devPanic(err)
+		return
+	}
+	c.Infof("submitting error to %s/%s returned status %d", owner, name, response.StatusCode)
+
+	defer response.Body.Close()
+	var issue struct {
+		URL     string `json:"html_url"`
+		Number  int    `json:"number"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&issue); err != nil {
+		c.Warningf("decoding issue response: %v", err)
+	}
+
+	if response.StatusCode != http.StatusCreated {
+		status := response.StatusCode
+		if status < 400 || status >= 500 {
+			status = http.StatusBadGateway
+		}
+		if issue.Message == "" {
+			issue.Message = response.Status
+		}
+		c.Warningf("submitting error to %s/%s failed: %s", owner, name, issue.Message)
+		result, _ := json.Marshal(map[string]string{"error": issue.Message})
+		w.WriteHeader(status)
+		w.Write(result)
+		return
+	}
+
+	result, _ := json.Marshal(struct {
+		URL    string `json:"url"`
+		Number int    `json:"number"`
+	}{issue.URL, issue.Number})
+	w.WriteHeader(http.StatusCreated)
+	w.Write(result)
+}
+
+func (repository Repository) optedIn(r *http.Request, channel string) bool {
+	gated := false
+	for _, optIn := range repository.OptInChannels {
+		if optIn == channel {
+			gated = true
+			break
+		}
+	}
+	if !gated {
+		return true
+	}
+
+	value := r.FormValue("optin")
+	if repository.OptInToken != "" {
+		return subtle.ConstantTimeCompare([]byte(value), []byte(repository.OptInToken)) == 1
+	}
+	return value == "true"
+}
+
+// published returns the repository as r may see it, without the versions of
+// the channels that r hasn't opted into or the error report targets, and
+// with the sizes in the units binarySizes asks for.
+func (repository Repository) published(r *http.Request) Repository {
+	versions := RepositoryVersions{}
+	for channel, version := range repository.Versions {
+		if repository.optedIn(r, channel) {
+			version.SizeHuman = humanSize(version.Size)
+			versions[channel] = version
+		}
+	}
+	repository.Versions = versions
+	repository.ErrorTargets = nil
+	return repository
+}
+
+func (repository Repository) assetForOS(version Version, os string) (Asset, bool) {
+	if os == "" {
+		os = repository.DefaultOS
+	}
+	name, ok := repository.assetByOS[os]
+	if !ok {
+		return Asset{}, false
+	}
+	for _, asset := range version.Assets {
+		if name.MatchString(asset.Name) {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+var (
+	assetYearBuild   = regexp.MustCompile(`-(20\d\d)\.(\d)(?:\.\d+)*\.(?:zip|jar)$`)
+	assetBranchBuild = regexp.MustCompile(`-(\d{3})(?:\.\d+)*\.(?:zip|jar)$`)
+)
+
+// buildBranch extracts the branch number from an IDE build such as
+// "IU-241.14494.240".
+func buildBranch(build string) int {
+	if idx := strings.Index(build, "-"); idx >= 0 {
+		build = build[idx+1:]
+	}
+	branch, err := strconv.Atoi(strings.SplitN(build, ".", 2)[0])
+	if err != nil {
+		return 0
+	}
+	return branch
+}
+
+// assetBranch returns the branch an asset name like "plugin-2024.1.zip" or
+// "plugin-1.0.2-241.zip" was built for. The build has to be the last part
+// of the name, right before the extension.
+func assetBranch(name string) int {
+	if match := assetYearBuild.FindStringSubmatch(name); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		release, _ := strconv.Atoi(match[2])
+		return (year-2000)*10 + release
+	}
+	if match := assetBranchBuild.FindStringSubmatch(name); match != nil {
+		branch, _ := strconv.Atoi(match[1])
+		return branch
+	}
+	return 0
+}
+
+// assetForBuild picks the asset built for the newest branch that is not newer
+// than build.
+func assetForBuild(version Version, build string) (Asset, bool) {
+	wanted := buildBranch(build)
+	if wanted == 0 {
+		return Asset{}, false
+	}
+
+	var (
+		best       Asset
+		bestBranch int
+	)
+	for _, asset := range version.Assets {
+		if !strings.HasSuffix(asset.Name, ".zip") && !strings.HasSuffix(asset.Name, ".jar") {
+			continue
+		}
+		branch := assetBranch(asset.Name)
+		if branch > 0 && branch <= wanted && branch > bestBranch {
+			best, bestBranch = asset, branch
+		}
+	}
+	return best, bestBranch > 0
+}
+
+func (repository Repository) ideaVersion(channel string) IdeaVersion {
+	version := IdeaVersion{
+		SinceBuild: defaultSinceBuild,
+	}
+	for _, configured := range []*IdeaVersion{repository.IdeaVersion, repository.ChannelIdeaVersions[channel]} {
+		if configured == nil {
+			continue
+		}
+		if configured.Min != "" {
+			version.Min = configured.Min
+		}
+		if configured.Max != "" {
+			version.Max = configured.Max
+		}
+		if configured.SinceBuild != "" {
+			version.SinceBuild = configured.SinceBuild
+		}
+		if configured.UntilBuild != "" {
+			version.UntilBuild = configured.UntilBuild
+		}
 	}
+	return version
+}
+
+func (repository Repository) displayVersion(name string) string {
+	if !repository.StripChannelSuffix {
+		return name
+	}
+
+	suffix := repository.suffix
+	if suffix == nil {
+		suffix = channelSuffix
+	}
+	return suffix.ReplaceAllString(name, "")
+}
 
-	var response []byte
+func parseTimestamp(value string) (time.Time, error) {
 	var err error
+	for _, layout := range timestampLayouts {
+		var parsed time.Time
+		if parsed, err = time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, err
+}
 
-	switch vars["format"] {
-	case "xml":
-		{
-			w.Header().Set("Content-Type", "application/xml")
-			response, err = xml.MarshalIndent(plugin, "", "    ")
-			response = []byte(xml.Header + string(response))
+func humanSize(size uint32) string {
+	unit, suffixes := 1000.0, []string{"kB", "MB", "GB"}
+	if BinarySizes {
+		unit, suffixes = 1024.0, []string{"KiB", "MiB", "GiB"}
+	}
+	if float64(size) < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value, suffix := float64(size)/unit, suffixes[0]
+	for _, next := range suffixes[1:] {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
+
+func releaseDate(date int64) string {
+	if date <= 0 {
+		return ""
+	}
+	return time.Unix(0, date*int64(time.Millisecond)).UTC().Format("20060102")
+}
+
+func channelPlugin(w http.ResponseWriter, r *http.Request, owner string, repository Repository, channel string) (IdeaPlugin, bool) {
+	if !repository.hasChannel(channel) || !repository.optedIn(r, channel) {
+		return IdeaPlugin{}, false
+	}
+	version := repository.Versions[channel]
+
+	description := repository.Description
+	if channel == "release" && version.Name == "" && repository.PrereleaseFallback {
+		published := repository.published(r)
+		prereleaseChannel, prerelease := "beta", published.Versions["beta"]
+		if alpha := published.Versions["alpha"]; alpha.Name != "" && alpha.Date > prerelease.Date {
+			prereleaseChannel, prerelease = "alpha", alpha
+		}
+		if prerelease.Name != "" {
+			version = prerelease
+			description += fmt.Sprintf(" (no stable release yet, serving %s build %s)", prereleaseChannel, prerelease.Name)
+			w.Header().Set("X-Wrigi-Prerelease", prereleaseChannel)
+		}
+	}
+
+	ideaVersion := repository.ideaVersion(channel)
+	if version.Name == "" && repository.Fallback != nil {
+		version = Version{
+			Name: repository.Fallback.Name,
+			Url:  repository.Fallback.Url,
+		}
+		if repository.Fallback.SinceBuild != "" {
+			ideaVersion.SinceBuild = repository.Fallback.SinceBuild
+		}
+		w.Header().Set("X-Wrigi-Stale", "true")
+	}
+
+	if overridesAllowed(r) {
+		sinceBuild, untilBuild := r.FormValue("sinceBuild"), r.FormValue("untilBuild")
+		if sinceBuild != "" {
+			ideaVersion.SinceBuild = sinceBuild
+		}
+		if untilBuild != "" {
+			ideaVersion.UntilBuild = untilBuild
+		}
+		if sinceBuild != "" || untilBuild != "" {
+			w.Header().Set("Cache-Control", "private, no-store")
+		}
+	}
+
+	if asset, ok := assetForBuild(version, r.FormValue("build")); ok {
+		version.Url = asset.Url
+		version.Size = asset.Size
+		version.Digest = asset.Digest
+	} else if asset, ok := repository.assetForOS(version, r.FormValue("os")); ok {
+		version.Url = asset.Url
+		version.Size = asset.Size
+		version.Digest = asset.Digest
+	}
+
+	ideaPlugin := IdeaPlugin{
+		Name:        repository.PluginName,
+		ID:          repository.Id + "." + channel,
+		Description: description,
+		Version:     repository.displayVersion(version.Name),
+		Size:        version.Size,
+		Date:        version.Date,
+		Url:         repository.homepage(owner),
+		DownloadUrl: repository.downloadURL(version.Tag, version.Url),
+		Downloads:   version.DownloadCount,
+		ChangeNotes: changeNotes(truncateNotes(version.Body, repository.homepage(owner)+"/releases/tag/"+version.Tag)),
+		Vendor:      repository.Vendor,
+		ReleaseDate: releaseDate(version.Date),
+		IdeaVersion: ideaVersion,
+		Rating:      repository.Rating,
+		Checksum:    version.Digest,
+	}
+
+	if repository.ReleaseVersion > 0 {
+		ideaPlugin.ReleaseVersion = fmt.Sprintf("%d", repository.ReleaseVersion)
+	}
+
+	return ideaPlugin, true
+}
+
+func negotiateFormat(r *http.Request, format string) string {
+	if format == "xml" || format == "json" {
+		return format
+	}
+
+	negotiated, best := "json", 0.0
+	for _, media := range strings.Split(r.Header.Get("Accept"), ",") {
+		parts := strings.Split(media, ";")
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
 		}
+
+		candidate := ""
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "application/xml", "text/xml":
+			candidate = "xml"
+		case "application/json":
+			candidate = "json"
+		}
+		if candidate != "" && quality > best {
+			negotiated, best = candidate, quality
+		}
+	}
+	return negotiated
+}
+
+func prettyOutput(r *http.Request, fallback bool) bool {
+	if pretty, err := strconv.ParseBool(r.FormValue("pretty")); err == nil {
+		return pretty
+	}
+	return fallback
+}
+
+func marshalFormat(w http.ResponseWriter, r *http.Request, format string, v interface{}) ([]byte, error) {
+	indent := ""
+	if prettyOutput(r, true) {
+		indent = "    "
+	}
+
+	switch format {
+	case "xml":
+		w.Header().Set("Content-Type", "application/xml")
+		response, err := xml.MarshalIndent(v, "", indent)
+		return []byte(xml.Header + string(response)), err
 	default:
-		{
-			w.Header().Set("Content-Type", "application/json")
-			response, err = json.MarshalIndent(plugin, "", "    ")
+		w.Header().Set("Content-Type", "application/json")
+		if indent == "" {
+			return json.Marshal(wrapJSON(r, v))
 		}
+		return json.MarshalIndent(wrapJSON(r, v), "", indent)
 	}
+}
+
+func ideaPluginHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
 
-	if err != nil && appengine.IsDevAppServer() {
-		panic(err)
+	owner, repository, ok := lookupRepository(vars["owner"], vars["repository"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
 	}
 
-	w.Write(response)
+	ideaPlugin, ok := channelPlugin(w, r, owner, repository, vars["channel"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	pluginCategory := PluginCategory{
+		Name:       repository.category(),
+		IdeaPlugin: ideaPlugin,
+	}
+
+	plugin := PluginRepository{
+		Ff:       strconv.Quote(repository.category()),
+		Category: pluginCategory,
+	}
+
+	format := negotiateFormat(r, vars["format"])
+	if format != vars["format"] {
+		w.Header().Add("Vary", "Accept")
+	}
+	response, err := marshalFormat(w, r, format, plugin)
+	if err != nil {
+		newContext(r).Errorf("encoding %s: %v", r.URL.Path, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	key := repositoryKey(vars["owner"], vars["repository"]) + "/" + vars["channel"] + "/" + format
+	writeCompressed(w, r, key, response)
+}
+
+// methodNotAllowed answers the methods a route doesn't take, so that the
+// request doesn't fall through to the channel routes and their CORS headers.
+func methodNotAllowed(allowed string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allowed)
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+	}
 }
 
 func init() {
 	initConfig()
 
 	r := mux.NewRouter()
-	r.HandleFunc("/", rootHandler).Methods("GET")
-	r.HandleFunc("/update", updateHandler)
-	r.HandleFunc("/{owner}/{repository}/submitError", submitErrorHandler).Methods("POST")
-	r.HandleFunc("/{owner}/{repository}/{channel}.{format}", ideaPluginHandler).Methods("GET")
-	r.HandleFunc("/{owner}/{repository}/{channel}/idea.{format}", ideaPluginHandler).Methods("GET")
-
-	//r.HandleFunc("/{owner}/{repository}/token", tokenHandler).Methods("GET")
-
-	http.Handle("/", r)
+	r.HandleFunc("/robots.txt", countRequests("/robots.txt", robotsHandler)).Methods("GET")
+	r.HandleFunc("/", countRequests("/", withRepositories(corsHandler(cacheHandler(gzipHandler(rootHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/update", countRequests("/update", adminHandler(withRepositories(timeoutHandler(updateHandler)))))
+	r.HandleFunc("/cron/update", countRequests("/cron/update", adminHandler(withRepositories(timeoutHandler(cronUpdateHandler)))))
+	r.HandleFunc("/webhook/github", countRequests("/webhook/github", withRepositories(timeoutHandler(webhookHandler)))).Methods("POST")
+	r.HandleFunc("/retryErrors", countRequests("/retryErrors", adminHandler(retryErrorsHandler)))
+	r.HandleFunc("/admin/reload", countRequests("/admin/reload", adminHandler(withRepositories(reloadHandler)))).Methods("POST")
+	r.HandleFunc("/version", countRequests("/version", versionHandler)).Methods("GET")
+	r.HandleFunc("/healthz", countRequests("/healthz", withRepositories(corsHandler(healthHandler)))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/lastUpdate", countRequests("/lastUpdate", withRepositories(corsHandler(lastUpdateHandler)))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/metrics", metricsHandler).Methods("GET")
+	r.HandleFunc("/stats", countRequests("/stats", withRepositories(corsHandler(cacheHandler(gzipHandler(statsHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/submitError", countRequests("/{owner}/{repository}/submitError", timeoutHandler(submitErrorHandler))).Methods("POST")
+	r.HandleFunc("/{owner}/{repository}/submitError", countRequests("/{owner}/{repository}/submitError", methodNotAllowed("POST")))
+	r.HandleFunc("/{owner}/{repository}/update", countRequests("/{owner}/{repository}/update", adminHandler(withRepositories(timeoutHandler(repositoryUpdateHandler)))))
+	r.HandleFunc("/{owner}/{repository}/channels.{format}", countRequests("/{owner}/{repository}/channels.{format}", withRepositories(corsHandler(cacheHandler(gzipHandler(channelsHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/history.{format}", countRequests("/{owner}/{repository}/history.{format}", withRepositories(corsHandler(historyHandler)))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/feed.atom", countRequests("/{owner}/{repository}/feed.atom", withRepositories(corsHandler(cacheHandler(gzipHandler(feedHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/plugins.xml", countRequests("/{owner}/{repository}/plugins.xml", withRepositories(corsHandler(cacheHandler(gzipHandler(allPluginsHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/{channel}.{format}", countRequests("/{owner}/{repository}/{channel}.{format}", withRepositories(corsHandler(cacheHandler(gzipHandler(ideaPluginHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/{channel}/idea.{format}", countRequests("/{owner}/{repository}/{channel}/idea.{format}", withRepositories(corsHandler(cacheHandler(gzipHandler(ideaPluginHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/{channel}/updatePlugins.{format}", countRequests("/{owner}/{repository}/{channel}/updatePlugins.{format}", withRepositories(corsHandler(cacheHandler(gzipHandler(updatePluginsHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/{channel}/download", countRequests("/{owner}/{repository}/{channel}/download", withRepositories(downloadHandler))).Methods("GET")
+	r.HandleFunc("/{owner}/{repository}/{channel}", countRequests("/{owner}/{repository}/{channel}", withRepositories(corsHandler(cacheHandler(gzipHandler(ideaPluginHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/{channel}/idea", countRequests("/{owner}/{repository}/{channel}/idea", withRepositories(corsHandler(cacheHandler(gzipHandler(ideaPluginHandler)))))).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{owner}/{repository}/{channel}/updatePlugins", countRequests("/{owner}/{repository}/{channel}/updatePlugins", withRepositories(corsHandler(cacheHandler(gzipHandler(updatePluginsHandler)))))).Methods("GET", "OPTIONS")
+
+	//r.HandleFunc("/{owner}/{repository}/token", countRequests("/{owner}/{repository}/token", tokenHandler)).Methods("GET")
+
+	http.Handle("/", requestIDHandler(r))
 }
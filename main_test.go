@@ -0,0 +1,2157 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends every request to the test server, whatever host it
+// was meant for.
+type redirectTransport struct {
+	host string
+}
+
+func (t redirectTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	redirected := request.Clone(request.Context())
+	redirected.URL.Scheme = "http"
+	redirected.URL.Host = t.host
+	return http.DefaultTransport.RoundTrip(redirected)
+}
+
+// roundTripFunc answers requests without a server.
+type roundTripFunc func(request *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// useConfig applies config with an empty store and cache, and restores the
+// default configuration when the test ends.
+func useConfig(t *testing.T, config string) {
+	t.Helper()
+	store, cache = newMemoryStore(), newMemoryCache()
+	loadRepositoriesOnce = sync.Once{}
+	configReloaded = time.Time{}
+	if err := applyConfig([]byte(config)); err != nil {
+		t.Fatalf("applying %s: %v", config, err)
+	}
+	t.Cleanup(func() {
+		applyConfig([]byte("{}"))
+	})
+}
+
+// stubAPI answers the GitHub and GitLab requests with handler, and forgets the
+// rate limits and circuit breakers the test leaves behind.
+func stubAPI(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	factory := httpClientFactory
+	httpClientFactory = func(r *http.Request) *http.Client {
+		transport := redirectTransport{host: strings.TrimPrefix(server.URL, "http://")}
+		return &http.Client{
+			Transport: contextTransport{ctx: requestContext(r), base: transport},
+			Timeout:   fetchTimeout,
+		}
+	}
+	t.Cleanup(func() {
+		httpClientFactory = factory
+		server.Close()
+
+		breakersLock.Lock()
+		breakers = map[string]*circuitBreaker{}
+		breakersLock.Unlock()
+		rateLimitLock.Lock()
+		rateLimits = map[string]RateLimit{}
+		rateLimitLock.Unlock()
+	})
+}
+
+// githubRelease returns the JSON of a GitHub release with a single zip asset.
+func githubRelease(tag string, prerelease bool) string {
+	return fmt.Sprintf(`{"name": %[1]q, "tag_name": %[1]q, "prerelease": %[2]v, "published_at": "2016-01-02T15:04:05Z",
+		"assets": [{"name": "plugin-%[1]s.zip", "browser_download_url": "https://example.com/plugin-%[1]s.zip"}]}`, tag, prerelease)
+}
+
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+	return w
+}
+
+func TestRootHandler(t *testing.T) {
+	useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	repositories[0].Repositories[0].Versions = RepositoryVersions{
+		"release": {Name: "1.2.0", Tag: "v1.2.0", Url: "https://example.com/plugin.zip"},
+	}
+	repositories[0].Repositories[0].lastSuccess = time.Now()
+
+	tests := []struct {
+		name   string
+		url    string
+		accept string
+		check  func(t *testing.T, body string)
+	}{
+		{
+			name: "feed",
+			url:  "/",
+			check: func(t *testing.T, body string) {
+				var feed struct {
+					LastUpdate   string `json:"lastUpdate"`
+					Stale        bool   `json:"stale"`
+					Repositories []Organization
+				}
+				if err := json.Unmarshal([]byte(body), &feed); err != nil {
+					t.Fatalf("decoding %s: %v", body, err)
+				}
+				if feed.Stale || feed.LastUpdate == "" {
+					t.Errorf("got stale=%v lastUpdate=%q, want a fresh feed", feed.Stale, feed.LastUpdate)
+				}
+				if len(feed.Repositories) != 1 || feed.Repositories[0].Repositories[0].Versions["release"].Name != "1.2.0" {
+					t.Errorf("unexpected repositories in %s", body)
+				}
+			},
+		},
+		{
+			name: "raw",
+			url:  "/?raw=true",
+			check: func(t *testing.T, body string) {
+				var organizations []Organization
+				if err := json.Unmarshal([]byte(body), &organizations); err != nil || len(organizations) != 1 {
+					t.Errorf("got %s, want one organization: %v", body, err)
+				}
+			},
+		},
+		{
+			name: "other owner",
+			url:  "/?owner=other&raw=true",
+			check: func(t *testing.T, body string) {
+				if strings.TrimSpace(body) != "[]" {
+					t.Errorf("got %s, want no organizations", body)
+				}
+			},
+		},
+		{
+			name:   "landing page",
+			url:    "/",
+			accept: "text/html",
+			check: func(t *testing.T, body string) {
+				if !strings.Contains(body, "1.2.0") {
+					t.Errorf("landing page doesn't list the release: %s", body)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.url, nil)
+			if test.accept != "" {
+				r.Header.Set("Accept", test.accept)
+			}
+			w := serve(r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", w.Code, w.Body)
+			}
+			if vary := w.Header().Get("Vary"); !strings.Contains(vary, "Accept") {
+				t.Errorf("got Vary %q, want Accept", vary)
+			}
+			if w.Header().Get("X-Request-ID") == "" {
+				t.Error("missing X-Request-ID")
+			}
+			test.check(t, w.Body.String())
+		})
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret"}`)
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "anonymous", status: http.StatusForbidden},
+		{name: "wrong token", authorization: "Bearer nope", status: http.StatusForbidden},
+		{name: "admin", authorization: "Bearer s3cret", status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/retryErrors", nil)
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			if w := serve(r); w.Code != test.status {
+				t.Errorf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+		})
+	}
+}
+
+// releasePages serves pages as the release listing of acme/plugin, linking
+// each page to the next one, and counts the requests for it.
+func releasePages(requests *int32, pages ...[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/acme/plugin" {
+			fmt.Fprint(w, `{"stargazers_count": 10}`)
+			return
+		}
+		if r.URL.Path != "/repos/acme/plugin/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(requests, 1)
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 1 || page > len(pages) {
+			page = 1
+		}
+		if page < len(pages) {
+			w.Header().Set("Link", fmt.Sprintf(`<https://api.github.com/repos/acme/plugin/releases?page=%d>; rel="next"`, page+1))
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(pages[page-1], ","))
+	}
+}
+
+const pluginConfig = `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`
+
+func TestUpdateRepositoryPagination(t *testing.T) {
+	alpha, beta, release := githubRelease("1.1.0-alpha", true), githubRelease("1.0.1-beta", true), githubRelease("1.0.0", false)
+
+	tests := []struct {
+		name     string
+		pages    [][]string
+		requests int32
+		want     map[string]string
+	}{
+		{
+			name:     "first page fills every channel",
+			pages:    [][]string{{alpha, beta, release}, {githubRelease("0.9.0", false)}},
+			requests: 1,
+			want:     map[string]string{"alpha": "1.1.0-alpha", "beta": "1.0.1-beta", "release": "1.0.0"},
+		},
+		{
+			name:     "follows the next link",
+			pages:    [][]string{{alpha}, {beta}, {release}},
+			requests: 3,
+			want:     map[string]string{"alpha": "1.1.0-alpha", "beta": "1.0.1-beta", "release": "1.0.0"},
+		},
+		{
+			name:     "stops on the last page",
+			pages:    [][]string{{alpha}, {beta}},
+			requests: 2,
+			want:     map[string]string{"alpha": "1.1.0-alpha", "beta": "1.0.1-beta", "release": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			var requests int32
+			stubAPI(t, releasePages(&requests, test.pages...))
+
+			repository, err := updateRepository(httptest.NewRequest("GET", "/update", nil), "acme", repositories[0].Repositories[0])
+			if err != nil {
+				t.Fatalf("updating: %v", err)
+			}
+			if got := atomic.LoadInt32(&requests); got != test.requests {
+				t.Errorf("got %d release requests, want %d", got, test.requests)
+			}
+			for channel, name := range test.want {
+				if got := repository.Versions[channel].Name; got != name {
+					t.Errorf("got %s version %q, want %q", channel, got, name)
+				}
+			}
+			if repository.Rating != 1.7 {
+				t.Errorf("got rating %v for 10 stargazers, want 1.7", repository.Rating)
+			}
+		})
+	}
+}
+
+func TestUpdateRepositoryETag(t *testing.T) {
+	tests := []struct {
+		name    string
+		changed bool
+		status  int
+		version string
+		etag    string
+	}{
+		{name: "not modified", status: http.StatusNotModified, version: "1.1.0", etag: `"v1"`},
+		{name: "modified", changed: true, status: http.StatusOK, version: "1.2.0", etag: `"v2"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			var requests int32
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/acme/plugin/releases" {
+					fmt.Fprint(w, `{"stargazers_count": 10}`)
+					return
+				}
+				n := atomic.AddInt32(&requests, 1)
+				etag := `"v1"`
+				if test.changed {
+					etag = fmt.Sprintf(`"v%d"`, n)
+				}
+				if r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("ETag", etag)
+				fmt.Fprintf(w, "[%s]", githubRelease(fmt.Sprintf("1.%d.0", n), false))
+			})
+
+			r := httptest.NewRequest("GET", "/update", nil)
+			repository, err := updateRepository(r, "acme", repositories[0].Repositories[0])
+			if err != nil {
+				t.Fatalf("first update: %v", err)
+			}
+			if repository.etag != `"v1"` {
+				t.Fatalf("got etag %q after the first update, want \"v1\"", repository.etag)
+			}
+
+			// The cached versions would spare the second request.
+			cache = newMemoryCache()
+			repository, err = updateRepository(r, "acme", repository)
+			if err != nil {
+				t.Fatalf("second update: %v", err)
+			}
+			if repository.lastStatus != test.status {
+				t.Errorf("got status %d, want %d", repository.lastStatus, test.status)
+			}
+			if got := repository.Versions["release"].Name; got != test.version {
+				t.Errorf("got release %q, want %q", got, test.version)
+			}
+			if repository.etag != test.etag {
+				t.Errorf("got etag %q, want %q", repository.etag, test.etag)
+			}
+		})
+	}
+}
+
+func TestFetchRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int32
+		wantErr  bool
+	}{
+		{name: "success", statuses: []int{200}, requests: 1},
+		{name: "recovers after server errors", statuses: []int{502, 503, 200}, requests: 3},
+		{name: "gives up after the last attempt", statuses: []int{500, 500, 500, 200}, requests: 3, wantErr: true},
+		{name: "client errors aren't retried", statuses: []int{404, 200}, requests: 1, wantErr: true},
+	}
+
+	backoff := fetchBackoffBase
+	fetchBackoffBase = time.Millisecond
+	t.Cleanup(func() { fetchBackoffBase = backoff })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"fetchAttempts": 3}`)
+			var requests int32
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&requests, 1)
+				if status := test.statuses[n-1]; status != 200 {
+					w.WriteHeader(status)
+					return
+				}
+				fmt.Fprintf(w, "[%s]", githubRelease("1.0.0", false))
+			})
+
+			r := httptest.NewRequest("GET", "/update", nil)
+			page, err := fetchReleases(httpClientFactory(r), Repository{}.logger(newContext(r)), "https://api.github.com/repos/acme/plugin/releases", "")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && len(page.releases) != 1 {
+				t.Errorf("got %d releases, want 1", len(page.releases))
+			}
+			if got := atomic.LoadInt32(&requests); got != test.requests {
+				t.Errorf("got %d requests, want %d", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestUpdateRepositoryDeadline(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		status int
+	}{
+		{name: "fetch timeout", config: `{"fetchTimeout": "50ms", "fetchAttempts": 1}`, status: http.StatusOK},
+		{name: "request timeout", config: `{"requestTimeout": "50ms"}`, status: http.StatusGatewayTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			})
+
+			repository := repositories[0].Repositories[0]
+			repository.Versions = RepositoryVersions{"release": {Name: "0.9.0"}}
+			var (
+				updated Repository
+				err     error
+			)
+			handler := timeoutHandler(func(w http.ResponseWriter, r *http.Request) {
+				updated, err = updateRepository(r, "go-lang-plugin-org", repository)
+			})
+
+			start := time.Now()
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest("GET", "/update", nil))
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("the update took %s", elapsed)
+			}
+			if rec.Code != test.status {
+				t.Errorf("got status %d, want %d", rec.Code, test.status)
+			}
+			if !isTimeout(err) {
+				t.Errorf("got error %v, want a timeout", err)
+			}
+			if got := updated.Versions["release"].Name; got != "0.9.0" {
+				t.Errorf("got release %q, want the existing 0.9.0", got)
+			}
+		})
+	}
+}
+
+func TestUpdateVersionsConcurrently(t *testing.T) {
+	var names []string
+	for idx := 0; idx < 10; idx++ {
+		names = append(names, fmt.Sprintf(`{"id": "com.acme.plugin%[1]d", "name": "plugin%[1]d"}`, idx))
+	}
+	organizations := `"organizations": [{"name": "acme", "repositories": [` + strings.Join(names, ",") + `]}]`
+
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{name: "one at a time", concurrency: 1},
+		{name: "four at a time", concurrency: 4},
+		{name: "all at once", concurrency: 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, fmt.Sprintf(`{"fetchConcurrency": %d, %s}`, test.concurrency, organizations))
+			var inFlight, maxInFlight int32
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				var idx int
+				if _, err := fmt.Sscanf(r.URL.Path, "/repos/acme/plugin%d/releases", &idx); err != nil {
+					fmt.Fprint(w, `{"stargazers_count": 10}`)
+					return
+				}
+				current := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				for {
+					seen := atomic.LoadInt32(&maxInFlight)
+					if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+						break
+					}
+				}
+				// Hold the fetches until as many as allowed are running.
+				for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&maxInFlight) < int32(test.concurrency) && time.Now().Before(deadline); {
+					time.Sleep(time.Millisecond)
+				}
+				fmt.Fprintf(w, "[%s]", githubRelease(fmt.Sprintf("1.%d.0", idx), false))
+			})
+
+			// Serve the feed while the update runs, for the race detector.
+			done := make(chan struct{})
+			var readers sync.WaitGroup
+			for _, url := range []string{"/", "/?raw=true", "/acme/plugin3/release"} {
+				readers.Add(1)
+				go func(url string) {
+					defer readers.Done()
+					for {
+						select {
+						case <-done:
+							return
+						case <-time.After(10 * time.Millisecond):
+							serve(httptest.NewRequest("GET", url, nil))
+						}
+					}
+				}(url)
+			}
+
+			statuses := updateVersions(httptest.NewRequest("GET", "/update", nil), true)
+			close(done)
+			readers.Wait()
+
+			if got := atomic.LoadInt32(&maxInFlight); got != int32(test.concurrency) {
+				t.Errorf("got %d concurrent fetches, want %d", got, test.concurrency)
+			}
+			if len(statuses) != 10 {
+				t.Fatalf("got %d statuses, want 10", len(statuses))
+			}
+			for idx, status := range statuses {
+				if !status.Updated || status.Repository != fmt.Sprintf("plugin%d", idx) {
+					t.Errorf("got status %+v for plugin%d", status, idx)
+				}
+				repository, _ := findRepository("acme", fmt.Sprintf("plugin%d", idx))
+				if want := fmt.Sprintf("1.%d.0", idx); repository.Versions["release"].Name != want {
+					t.Errorf("got plugin%d release %q, want %q", idx, repository.Versions["release"].Name, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOptInChannels(t *testing.T) {
+	gates := []struct {
+		name   string
+		config string
+		optIn  string
+	}{
+		{name: "optin parameter", config: `"optInChannels": ["beta"]`, optIn: "optin=true"},
+		{name: "token", config: `"optInChannels": ["beta"], "optInToken": "letmein"`, optIn: "optin=letmein"},
+	}
+	pages := []struct {
+		url    string
+		accept string
+	}{
+		{url: "/acme/plugin/beta.xml"},
+		{url: "/acme/plugin/plugins.xml"},
+		{url: "/acme/plugin/channels.json"},
+		{url: "/acme/plugin/feed.atom"},
+		{url: "/"},
+		{url: "/", accept: "text/html"},
+	}
+
+	for _, gate := range gates {
+		for _, page := range pages {
+			for _, optedIn := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s %s %s opted in %v", gate.name, page.url, page.accept, optedIn), func(t *testing.T) {
+					useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", `+gate.config+`}]}]}`)
+					repositories[0].Repositories[0].Versions = RepositoryVersions{
+						"release": {Name: "1.0.0", Tag: "v1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+						"beta":    {Name: "1.1.0-beta", Tag: "v1.1.0-beta", Url: "https://example.com/plugin-1.1.0-beta.zip"},
+					}
+
+					url := page.url
+					if optedIn {
+						url += "?" + gate.optIn
+					}
+					r := httptest.NewRequest("GET", url, nil)
+					if page.accept != "" {
+						r.Header.Set("Accept", page.accept)
+					}
+					w := serve(r)
+
+					shown := strings.Contains(w.Body.String(), "1.1.0-beta")
+					if shown != optedIn {
+						t.Errorf("got the beta shown %v, want %v: %s", shown, optedIn, w.Body)
+					}
+					if page.url == "/acme/plugin/beta.xml" {
+						if want := map[bool]int{false: http.StatusNotFound, true: http.StatusOK}[optedIn]; w.Code != want {
+							t.Errorf("got status %d, want %d", w.Code, want)
+						}
+					} else if !strings.Contains(w.Body.String(), "1.0.0") {
+						t.Errorf("the release is missing: %s", w.Body)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestOptInPrereleaseFallback(t *testing.T) {
+	useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", "optInChannels": ["beta"], "prereleaseFallback": true}]}]}`)
+	repositories[0].Repositories[0].Versions = RepositoryVersions{
+		"beta": {Name: "1.1.0-beta", Tag: "v1.1.0-beta", Url: "https://example.com/plugin-1.1.0-beta.zip"},
+	}
+
+	for _, test := range []struct {
+		url   string
+		shown bool
+	}{
+		{url: "/acme/plugin/release.xml"},
+		{url: "/acme/plugin/release.xml?optin=true", shown: true},
+	} {
+		w := serve(httptest.NewRequest("GET", test.url, nil))
+		if shown := strings.Contains(w.Body.String(), "1.1.0-beta"); shown != test.shown {
+			t.Errorf("%s: got the beta served %v, want %v", test.url, shown, test.shown)
+		}
+	}
+}
+
+// nativeAssets returns a release version with one asset per OS.
+func nativeAssets() RepositoryVersions {
+	version := Version{Name: "1.0.0", Tag: "v1.0.0", Url: "https://example.com/plugin.zip"}
+	for _, os := range []string{"linux", "mac", "win"} {
+		version.Assets = append(version.Assets, Asset{Name: "plugin-" + os + ".zip", Url: "https://example.com/plugin-" + os + ".zip"})
+	}
+	return RepositoryVersions{"release": version}
+}
+
+func TestAssetByOS(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "mac", url: "/acme/plugin/release.xml?os=mac", want: "https://example.com/plugin-mac.zip"},
+		{name: "windows", url: "/acme/plugin/release.xml?os=win", want: "https://example.com/plugin-win.zip"},
+		{name: "default OS", url: "/acme/plugin/release.xml", want: "https://example.com/plugin-linux.zip"},
+		{name: "unknown OS", url: "/acme/plugin/release.xml?os=beos", want: "https://example.com/plugin.zip"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", "defaultOS": "linux",
+				"assetByOS": {"linux": "-linux\\.zip$", "mac": "-mac\\.zip$", "win": "-win\\.zip$"}}]}]}`)
+			repositories[0].Repositories[0].Versions = nativeAssets()
+
+			w := serve(httptest.NewRequest("GET", test.url, nil))
+			if !strings.Contains(w.Body.String(), "<downloadUrl>"+test.want+"</downloadUrl>") {
+				t.Errorf("got %s, want the download URL %s", w.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+	}{
+		{name: "channel", settings: `"channels": [{"name": "release", "pattern": "("}]`},
+		{name: "asset", settings: `"assetPattern": "[a-"`},
+		{name: "channel suffix", settings: `"stripChannelSuffix": true, "channelSuffixPattern": "-(rc"`},
+		{name: "asset by OS", settings: `"assetByOS": {"mac": "*.zip"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			config := `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", ` + test.settings + `}]}]}`
+			if err := applyConfig([]byte(config)); err == nil {
+				t.Errorf("the config was accepted")
+			}
+
+			// An override with the same pattern is ignored on its own.
+			override := `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}], "repositories": {"acme/plugin": {` + test.settings + `}}}`
+			if err := applyConfig([]byte(override)); err != nil {
+				t.Fatalf("applying the override: %v", err)
+			}
+			repository, ok := findRepository("acme", "plugin")
+			if !ok || len(repository.Channels) > 0 || repository.AssetPattern != "" || len(repository.AssetByOS) > 0 {
+				t.Errorf("got %+v, want the repository without the invalid override", repository)
+			}
+		})
+	}
+}
+
+func TestStripChannelSuffix(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		version  string
+		want     string
+	}{
+		{name: "kept", settings: `"stripChannelSuffix": false`, version: "1.2.3-beta", want: "1.2.3-beta"},
+		{name: "default pattern", settings: `"stripChannelSuffix": true`, version: "1.2.3-beta", want: "1.2.3"},
+		{name: "numbered suffix", settings: `"stripChannelSuffix": true`, version: "1.2.3.beta2", want: "1.2.3"},
+		{name: "custom pattern", settings: `"stripChannelSuffix": true, "channelSuffixPattern": "-rc[0-9]+$"`, version: "1.2.3-rc4", want: "1.2.3"},
+		{name: "custom pattern keeps other suffixes", settings: `"stripChannelSuffix": true, "channelSuffixPattern": "-rc[0-9]+$"`, version: "1.2.3-beta", want: "1.2.3-beta"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", `+test.settings+`}]}]}`)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{"beta": {Name: test.version, Tag: "v" + test.version, Url: "https://example.com/plugin.zip"}}
+
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/beta.xml", nil))
+			if !strings.Contains(w.Body.String(), "<version>"+test.want+"</version>") {
+				t.Errorf("got %s, want version %s", w.Body, test.want)
+			}
+			if repository, _ := findRepository("acme", "plugin"); repository.Versions["beta"].Name != test.version {
+				t.Errorf("got the stored name %q, want %q", repository.Versions["beta"].Name, test.version)
+			}
+		})
+	}
+}
+
+func TestAssetForBuild(t *testing.T) {
+	branches := Version{Url: "https://example.com/plugin.zip", Assets: []Asset{
+		{Name: "plugin-1.0.100-233.zip", Url: "https://example.com/plugin-233.zip"},
+		{Name: "plugin-1.0.100-241.zip", Url: "https://example.com/plugin-241.zip"},
+	}}
+	years := Version{Url: "https://example.com/plugin.zip", Assets: []Asset{
+		{Name: "plugin-2023.2.zip", Url: "https://example.com/plugin-2023.2.zip"},
+		{Name: "plugin-2024.1.3.zip", Url: "https://example.com/plugin-2024.1.zip"},
+	}}
+	unversioned := Version{Url: "https://example.com/plugin.zip", Assets: []Asset{
+		{Name: "plugin-1.0.100.zip", Url: "https://example.com/plugin-1.0.100.zip"},
+		{Name: "plugin-241-sources.zip", Url: "https://example.com/plugin-sources.zip"},
+	}}
+
+	tests := []struct {
+		name    string
+		version Version
+		build   string
+		want    string
+	}{
+		{name: "newest branch", version: branches, build: "IU-241.14494.240", want: "https://example.com/plugin-241.zip"},
+		{name: "older branch", version: branches, build: "IC-233.11799.241", want: "https://example.com/plugin-233.zip"},
+		{name: "newer IDE", version: branches, build: "IU-252.1", want: "https://example.com/plugin-241.zip"},
+		{name: "too old", version: branches, build: "IU-232.1"},
+		{name: "year builds", version: years, build: "IU-241.14494.240", want: "https://example.com/plugin-2024.1.zip"},
+		{name: "older year build", version: years, build: "IU-233.1", want: "https://example.com/plugin-2023.2.zip"},
+		{name: "version without a build", version: unversioned, build: "IU-241.14494.240"},
+		{name: "no build", version: branches},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			asset, ok := assetForBuild(test.version, test.build)
+			if ok != (test.want != "") || asset.Url != test.want {
+				t.Errorf("got %q (%v), want %q", asset.Url, ok, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdateFailures(t *testing.T) {
+	const twoPlugins = `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}, {"id": "com.acme.tool", "name": "tool"}]}]}`
+
+	tests := []struct {
+		name   string
+		broken map[string]bool
+		status int
+		failed int
+	}{
+		{name: "all updated", status: http.StatusOK},
+		{name: "one failed", broken: map[string]bool{"tool": true}, status: http.StatusOK, failed: 1},
+		{name: "all failed", broken: map[string]bool{"plugin": true, "tool": true}, status: http.StatusBadGateway, failed: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, twoPlugins)
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				var name string
+				if _, err := fmt.Sscanf(r.URL.Path, "/repos/acme/%s", &name); err == nil && test.broken[strings.TrimSuffix(name, "/releases")] {
+					fmt.Fprint(w, `[{"name": `)
+					return
+				}
+				if strings.HasSuffix(r.URL.Path, "/releases") {
+					fmt.Fprintf(w, "[%s]", githubRelease("1.0.0", false))
+					return
+				}
+				fmt.Fprint(w, `{"stargazers_count": 10}`)
+			})
+
+			r := httptest.NewRequest("GET", "/update", nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			w := serve(r)
+			if w.Code != test.status {
+				t.Errorf("got status %d, want %d", w.Code, test.status)
+			}
+			var status UpdateStatus
+			if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			failed := 0
+			for _, repository := range status.Repositories {
+				if repository.Error != "" {
+					failed++
+				}
+			}
+			if failed != test.failed {
+				t.Errorf("got %d failed repositories, want %d: %s", failed, test.failed, w.Body)
+			}
+		})
+	}
+}
+
+func TestRootFeedStale(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		updated time.Duration
+		stale   bool
+	}{
+		{name: "never updated", config: pluginConfig, stale: true},
+		{name: "fresh", config: pluginConfig, updated: time.Minute},
+		{name: "older than the interval", config: pluginConfig, updated: 6 * time.Minute, stale: true},
+		{name: "within a custom interval", config: `{"update_interval": "1h", ` + pluginConfig[1:], updated: 30 * time.Minute},
+		{name: "older than a custom interval", config: `{"update_interval": "1h", ` + pluginConfig[1:], updated: 90 * time.Minute, stale: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+			if test.updated > 0 {
+				repositories[0].Repositories[0].lastSuccess = time.Now().Add(-test.updated)
+			}
+
+			w := serve(httptest.NewRequest("GET", "/", nil))
+			var feed RootFeed
+			if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if feed.Stale != test.stale {
+				t.Errorf("got stale %v, want %v", feed.Stale, test.stale)
+			}
+			if (feed.LastUpdate == "") != (test.updated == 0) {
+				t.Errorf("got lastUpdate %q", feed.LastUpdate)
+			}
+		})
+	}
+}
+
+func TestStarRating(t *testing.T) {
+	tests := []struct {
+		stars       int
+		ratingStars int
+		want        float32
+	}{
+		{stars: 0, ratingStars: 1000, want: 0},
+		{stars: 1, ratingStars: 1000, want: 0.5},
+		{stars: 10, ratingStars: 1000, want: 1.7},
+		{stars: 100, ratingStars: 1000, want: 3.3},
+		{stars: 1000, ratingStars: 1000, want: 5},
+		{stars: 5000, ratingStars: 1000, want: 5},
+		{stars: 10, ratingStars: 100, want: 2.6},
+		{stars: 10, ratingStars: 0, want: 0},
+	}
+
+	ratingStars := RatingStars
+	t.Cleanup(func() { RatingStars = ratingStars })
+
+	for _, test := range tests {
+		RatingStars = test.ratingStars
+		if got := starRating(test.stars); got != test.want {
+			t.Errorf("got rating %v for %d stars out of %d, want %v", got, test.stars, test.ratingStars, test.want)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions RepositoryVersions
+		channel  string
+		stale    bool
+		want     []string
+	}{
+		{
+			name:    "never updated",
+			channel: "release",
+			stale:   true,
+			want:    []string{"<version>0.9.0</version>", "<downloadUrl>https://example.com/fallback.zip</downloadUrl>", `since-build="201.1"`},
+		},
+		{
+			name:     "empty channel",
+			versions: RepositoryVersions{"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"}},
+			channel:  "beta",
+			stale:    true,
+			want:     []string{"<version>0.9.0</version>", "<downloadUrl>https://example.com/fallback.zip</downloadUrl>"},
+		},
+		{
+			name:     "released channel",
+			versions: RepositoryVersions{"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"}},
+			channel:  "release",
+			want:     []string{"<version>1.0.0</version>", "<downloadUrl>https://example.com/plugin-1.0.0.zip</downloadUrl>"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin",
+				"fallback": {"name": "0.9.0", "url": "https://example.com/fallback.zip", "sinceBuild": "201.1"}}]}]}`)
+			repositories[0].Repositories[0].Versions = test.versions
+
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/"+test.channel+".xml", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", w.Code, w.Body)
+			}
+			if stale := w.Header().Get("X-Wrigi-Stale") == "true"; stale != test.stale {
+				t.Errorf("got X-Wrigi-Stale %q, want stale=%v", w.Header().Get("X-Wrigi-Stale"), test.stale)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("got %s, want %s", w.Body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateIntervals(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    []string
+	}{
+		{name: "neither due", elapsed: 30 * time.Second, want: nil},
+		{name: "hot due", elapsed: 10 * time.Minute, want: []string{"hot"}},
+		{name: "both due", elapsed: 2 * time.Hour, want: []string{"cold", "hot"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"update_interval": "1h", "organizations": [{"name": "acme", "repositories": [
+				{"id": "com.acme.hot", "name": "hot", "updateInterval": "1m"},
+				{"id": "com.acme.cold", "name": "cold"}]}]}`)
+			for ridx := range repositories[0].Repositories {
+				repositories[0].Repositories[ridx].lastFetch = time.Now().Add(-test.elapsed)
+			}
+
+			var (
+				lock    sync.Mutex
+				fetched []string
+			)
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/releases") {
+					lock.Lock()
+					fetched = append(fetched, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/acme/"), "/releases"))
+					lock.Unlock()
+					fmt.Fprintf(w, "[%s]", githubRelease("1.0.0", false))
+					return
+				}
+				fmt.Fprint(w, `{"stargazers_count": 1}`)
+			})
+
+			updateVersions(httptest.NewRequest("GET", "/update", nil), false)
+
+			sort.Strings(fetched)
+			if strings.Join(fetched, ",") != strings.Join(test.want, ",") {
+				t.Errorf("fetched %v, want %v", fetched, test.want)
+			}
+		})
+	}
+}
+
+func TestIdeaVersionOverride(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		secret string
+		want   string
+		cached bool
+	}{
+		{name: "secret", query: "?sinceBuild=242.1&untilBuild=242.*", secret: "s3cret", want: `<idea-version since-build="242.1" until-build="242.*">`},
+		{name: "wrong secret", query: "?sinceBuild=242.1", secret: "nope", want: `<idea-version since-build="139.1111">`, cached: true},
+		{name: "no secret", query: "?sinceBuild=242.1", want: `<idea-version since-build="139.1111">`, cached: true},
+		{name: "no override", secret: "s3cret", want: `<idea-version since-build="139.1111">`, cached: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"updateSecret": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			}
+
+			r := httptest.NewRequest("GET", "/acme/plugin/release.xml"+test.query, nil)
+			if test.secret != "" {
+				r.Header.Set("X-Wrigi-Secret", test.secret)
+			}
+			w := serve(r)
+			if !strings.Contains(w.Body.String(), test.want) {
+				t.Errorf("got %s, want %s", w.Body, test.want)
+			}
+			if cached := w.Header().Get("Cache-Control") != "private, no-store"; cached != test.cached {
+				t.Errorf("got Cache-Control %q, want cacheable=%v", w.Header().Get("Cache-Control"), test.cached)
+			}
+		})
+	}
+}
+
+func TestRepositoryKeyNormalization(t *testing.T) {
+	useConfig(t, `{"compressionThreshold": 0, "organizations": [{"name": "Acme", "repositories": [{"id": "com.acme.plugin", "name": "Plugin"}]}]}`)
+	repositories[0].Repositories[0].Versions = RepositoryVersions{
+		"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+	}
+	invalidateCompressedCache()
+
+	var bodies []string
+	for _, url := range []string{"/acme/plugin/release.xml", "/ACME/Plugin/release.xml"} {
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := serve(r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want 200: %s", url, w.Code, w.Body)
+		}
+		bodies = append(bodies, w.Body.String())
+	}
+
+	if bodies[0] != bodies[1] {
+		t.Errorf("got different responses for the same repository:\n%s\n%s", gunzip(t, strings.NewReader(bodies[0])), gunzip(t, strings.NewReader(bodies[1])))
+	}
+	compressedCacheLock.Lock()
+	defer compressedCacheLock.Unlock()
+	if _, ok := compressedCache["acme/plugin/release/xml"]; !ok || len(compressedCache) != 1 {
+		t.Errorf("got cache entries %v, want one for acme/plugin/release/xml", compressedCache)
+	}
+	if key := repositoryKey(" Acme", "PLUGIN"); key != "acme/plugin" {
+		t.Errorf("got key %q, want acme/plugin", key)
+	}
+}
+
+func TestReleaseDateAttributes(t *testing.T) {
+	published := time.Date(2016, 1, 2, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		settings string
+		date     int64
+		want     string
+	}{
+		{
+			name:     "date and version",
+			settings: `, "releaseVersion": 3`,
+			date:     published.UnixNano() / int64(time.Millisecond),
+			want:     `<idea-plugin downloads="0" size="0" date="1451777400000" release-date="20160102" release-version="3" url="https://github.com/acme/plugin">`,
+		},
+		{
+			name: "missing",
+			want: `<idea-plugin downloads="0" size="0" date="0" url="https://github.com/acme/plugin">`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"`+test.settings+`}]}]}`)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip", Date: test.date},
+			}
+
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/release.xml", nil))
+			if !strings.Contains(w.Body.String(), test.want) {
+				t.Errorf("got %s, want %s", w.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestJSONEnvelope(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope bool
+		url      string
+		want     bool
+	}{
+		{name: "root by default", url: "/", want: false},
+		{name: "root requested", url: "/?envelope=true", want: true},
+		{name: "channel by default", url: "/acme/plugin/release.json", want: false},
+		{name: "channel requested", url: "/acme/plugin/release.json?envelope=1", want: true},
+		{name: "configured", envelope: true, url: "/acme/plugin/release.json", want: true},
+		{name: "configured but declined", envelope: true, url: "/acme/plugin/release.json?envelope=false", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, fmt.Sprintf(`{"jsonEnvelope": %v, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`, test.envelope))
+
+			w := serve(httptest.NewRequest("GET", test.url, nil))
+			var envelope struct {
+				SchemaVersion int             `json:"schemaVersion"`
+				GeneratedAt   string          `json:"generatedAt"`
+				Data          json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if got := envelope.SchemaVersion == jsonSchemaVersion && envelope.GeneratedAt != "" && len(envelope.Data) > 0; got != test.want {
+				t.Errorf("got %s, want an envelope: %v", w.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdateRateLimited(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [
+		{"id": "com.acme.fresh", "name": "fresh"}, {"id": "com.acme.stale", "name": "stale"}]}]}`)
+	fetched := time.Now().Add(-time.Minute).Truncate(time.Second)
+	repositories[0].Repositories[0].lastFetch = fetched
+
+	reset := time.Now().Add(10 * time.Minute)
+	rateLimitLock.Lock()
+	rateLimits = map[string]RateLimit{"": {Remaining: 0, Reset: reset}}
+	rateLimitLock.Unlock()
+	t.Cleanup(func() {
+		rateLimitLock.Lock()
+		rateLimits = map[string]RateLimit{}
+		rateLimitLock.Unlock()
+	})
+
+	r := httptest.NewRequest("GET", "/update", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	w := serve(r)
+
+	var status UpdateStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if !strings.Contains(status.Message, "rate limit") || status.ResetAt != reset.UTC().Format(time.RFC3339) {
+		t.Errorf("got message %q reset %q, want the rate limit resetting at %s", status.Message, status.ResetAt, reset.UTC().Format(time.RFC3339))
+	}
+	want := []RepositoryStatus{
+		{Owner: "acme", Repository: "fresh", LastFetch: fetched.UTC().Format(time.RFC3339)},
+		{Owner: "acme", Repository: "stale", Stale: true},
+	}
+	if fmt.Sprint(status.Repositories) != fmt.Sprint(want) {
+		t.Errorf("got repositories %+v, want %+v", status.Repositories, want)
+	}
+}
+
+func TestUntaggedReleases(t *testing.T) {
+	untagged := `{"name": "2.0.0", "tag_name": "", "published_at": "2016-02-02T15:04:05Z",
+		"assets": [{"name": "plugin-2.0.0.zip", "browser_download_url": "https://example.com/plugin-2.0.0.zip"}]}`
+
+	tests := []struct {
+		name     string
+		releases []string
+		want     string
+	}{
+		{name: "next to a tagged release", releases: []string{untagged, githubRelease("1.0.0", false)}, want: "1.0.0"},
+		{name: "alone", releases: []string{untagged}, want: "0.9.0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "0.9.0", Tag: "0.9.0", Url: "https://example.com/plugin-0.9.0.zip"},
+			}
+			var requests int32
+			stubAPI(t, releasePages(&requests, test.releases))
+
+			repository, err := updateRepository(httptest.NewRequest("GET", "/update", nil), "acme", repositories[0].Repositories[0])
+			if err != nil {
+				t.Fatalf("updating: %v", err)
+			}
+			if got := repository.Versions["release"].Name; got != test.want {
+				t.Errorf("got release %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPrereleaseFallback(t *testing.T) {
+	beta := Version{Name: "1.1.0-beta", Tag: "v1.1.0-beta", Url: "https://example.com/plugin-1.1.0-beta.zip", Date: 2000}
+	alpha := Version{Name: "1.2.0-alpha", Tag: "v1.2.0-alpha", Url: "https://example.com/plugin-1.2.0-alpha.zip", Date: 3000}
+	release := Version{Name: "1.0.0", Tag: "v1.0.0", Url: "https://example.com/plugin-1.0.0.zip", Date: 1000}
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		versions RepositoryVersions
+		version  string
+		marker   string
+	}{
+		{name: "off by default", versions: RepositoryVersions{"beta": beta}, version: "<version></version>"},
+		{name: "fills release from beta", enabled: true, versions: RepositoryVersions{"beta": beta}, version: "<version>1.1.0-beta</version>", marker: "beta"},
+		{name: "newest prerelease", enabled: true, versions: RepositoryVersions{"beta": beta, "alpha": alpha}, version: "<version>1.2.0-alpha</version>", marker: "alpha"},
+		{name: "stable exists", enabled: true, versions: RepositoryVersions{"beta": beta, "release": release}, version: "<version>1.0.0</version>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, fmt.Sprintf(`{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", "prereleaseFallback": %v}]}]}`, test.enabled))
+			repositories[0].Repositories[0].Versions = test.versions
+
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/release.xml", nil))
+			if !strings.Contains(w.Body.String(), test.version) {
+				t.Errorf("got %s, want %s", w.Body, test.version)
+			}
+			if marker := w.Header().Get("X-Wrigi-Prerelease"); marker != test.marker {
+				t.Errorf("got X-Wrigi-Prerelease %q, want %q", marker, test.marker)
+			}
+			if marked := strings.Contains(w.Body.String(), "no stable release yet"); marked != (test.marker != "") {
+				t.Errorf("got the description marked %v, want %v", marked, test.marker != "")
+			}
+		})
+	}
+}
+
+func TestReleaseAssets(t *testing.T) {
+	assets := func(names ...string) []GithubReleaseAsset {
+		var assets []GithubReleaseAsset
+		for _, name := range names {
+			assets = append(assets, GithubReleaseAsset{Name: name, URL: "https://example.com/" + name})
+		}
+		return assets
+	}
+
+	tests := []struct {
+		name       string
+		repository Repository
+		release    GithubRelease
+		want       string
+	}{
+		{
+			name:    "no assets",
+			release: GithubRelease{TagName: "1.0.0", ZipballURL: "https://example.com/1.0.0.zipball"},
+			want:    "",
+		},
+		{
+			name:       "source zipball",
+			repository: Repository{SourceArchives: true},
+			release:    GithubRelease{TagName: "1.0.0", ZipballURL: "https://example.com/1.0.0.zipball", TarballURL: "https://example.com/1.0.0.tarball"},
+			want:       "https://example.com/1.0.0.zipball",
+		},
+		{
+			name:       "source tarball",
+			repository: Repository{SourceArchives: true},
+			release:    GithubRelease{TagName: "1.0.0", TarballURL: "https://example.com/1.0.0.tarball"},
+			want:       "https://example.com/1.0.0.tarball",
+		},
+		{
+			name:       "second of three matches the pattern",
+			repository: Repository{AssetPattern: `-signed\.zip$`},
+			release:    GithubRelease{TagName: "1.0.0", Assets: assets("plugin-1.0.0.zip", "plugin-1.0.0-signed.zip", "checksums.txt")},
+			want:       "https://example.com/plugin-1.0.0-signed.zip",
+		},
+		{
+			name:    "first archive without a pattern",
+			release: GithubRelease{TagName: "1.0.0", Assets: assets("checksums.txt", "plugin-1.0.0.jar", "plugin-1.0.0.zip")},
+			want:    "https://example.com/plugin-1.0.0.jar",
+		},
+		{
+			name:       "first asset when nothing matches",
+			repository: Repository{AssetPattern: `-signed\.zip$`},
+			release:    GithubRelease{TagName: "1.0.0", Assets: assets("checksums.txt", "notes.md")},
+			want:       "https://example.com/checksums.txt",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := test.repository
+			if err := repository.compilePatterns(); err != nil {
+				t.Fatalf("compiling the patterns: %v", err)
+			}
+			repository.Name, repository.Versions = "plugin", RepositoryVersions{}
+			log := repository.logger(newContext(httptest.NewRequest("GET", "/update", nil)))
+			release := test.release
+			release.PublishedAt = "2016-01-02T15:04:05Z"
+
+			classifyReleases(log, "acme", &repository, []GithubRelease{release})
+			if got := repository.Versions["release"].Url; got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	var (
+		fetches int32
+		started = make(chan struct{})
+		proceed = make(chan struct{})
+	)
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acme/plugin/releases" {
+			fmt.Fprint(w, `{"stargazers_count": 1}`)
+			return
+		}
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			close(started)
+			<-proceed
+		}
+		fmt.Fprintf(w, "[%s]", githubRelease("1.0.0", false))
+	})
+	update := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/update", nil)
+		r.Header.Set("Authorization", "Bearer s3cret")
+		return serve(r)
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- update() }()
+	<-started
+
+	second := update()
+	close(proceed)
+	if !strings.Contains(second.Body.String(), "already in progress") {
+		t.Errorf("got %s, want the update in progress", second.Body)
+	}
+	if w := <-first; !strings.Contains(w.Body.String(), `"updated":true`) {
+		t.Errorf("got %s, want the repository updated", w.Body)
+	}
+	if fetches := atomic.LoadInt32(&fetches); fetches != 1 {
+		t.Errorf("got %d fetches, want 1", fetches)
+	}
+}
+
+func TestReleaseFlags(t *testing.T) {
+	release := func(tag string, prerelease, draft bool) GithubRelease {
+		return GithubRelease{TagName: tag, Prerelease: prerelease, Draft: draft, PublishedAt: "2016-01-02T15:04:05Z",
+			Assets: []GithubReleaseAsset{{Name: "plugin-" + tag + ".zip", URL: "https://example.com/plugin-" + tag + ".zip"}}}
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   map[string]string
+	}{
+		{name: "drafts skipped", config: `{}`, want: map[string]string{"release": "v1.2.3", "beta": "2024.1-EAP"}},
+		{name: "drafts included", config: `{"include_drafts": true}`, want: map[string]string{"release": "v1.2.3", "beta": "2024.1-EAP", "draft": "v1.3.0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+			repository := Repository{Name: "plugin", Versions: RepositoryVersions{}}
+			log := repository.logger(newContext(httptest.NewRequest("GET", "/update", nil)))
+
+			classifyReleases(log, "acme", &repository, []GithubRelease{
+				release("v1.3.0", false, true),
+				release("2024.1-EAP", true, false),
+				release("v1.2.3", false, false),
+			})
+
+			got := map[string]string{}
+			for channel, version := range repository.Versions {
+				got[channel] = version.Tag
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIdeaVersionConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		channel  string
+		want     string
+	}{
+		{name: "defaults", channel: "release", want: `<idea-version since-build="139.1111"></idea-version>`},
+		{
+			name:     "repository",
+			settings: `, "ideaVersion": {"sinceBuild": "233.1", "untilBuild": "241.*"}`,
+			channel:  "release",
+			want:     `<idea-version since-build="233.1" until-build="241.*"></idea-version>`,
+		},
+		{
+			name:     "channel overrides the repository",
+			settings: `, "ideaVersion": {"sinceBuild": "233.1", "max": "2024.1"}, "channelIdeaVersions": {"beta": {"sinceBuild": "241.1"}}`,
+			channel:  "beta",
+			want:     `<idea-version max="2024.1" since-build="241.1"></idea-version>`,
+		},
+		{
+			name:     "other channels keep the repository's",
+			settings: `, "ideaVersion": {"sinceBuild": "233.1"}, "channelIdeaVersions": {"beta": {"sinceBuild": "241.1"}}`,
+			channel:  "release",
+			want:     `<idea-version since-build="233.1"></idea-version>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"`+test.settings+`}]}]}`)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				test.channel: {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			}
+
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/"+test.channel+".xml", nil))
+			if !strings.Contains(w.Body.String(), test.want) {
+				t.Errorf("got %s, want %s", w.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestReleaseDate(t *testing.T) {
+	tests := []struct {
+		name      string
+		published string
+		created   string
+		want      time.Time
+		now       bool
+	}{
+		{name: "published wins", published: "2016-01-02T15:04:05Z", created: "2015-12-31T10:00:00Z", want: time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{name: "asset creation", created: "2015-12-31T10:00:00Z", want: time.Date(2015, 12, 31, 10, 0, 0, 0, time.UTC)},
+		{name: "zero offset", published: "2016-01-02T15:04:05+00:00", want: time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{name: "offset", published: "2016-01-02T17:04:05+02:00", want: time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{name: "fractional seconds", published: "2016-01-02T15:04:05.123456Z", want: time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{name: "no zone", published: "2016-01-02T15:04:05", want: time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{name: "date only", published: "2016-01-02", want: time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{name: "unparsable", published: "yesterday", now: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := Repository{Name: "plugin", Versions: RepositoryVersions{}}
+			var logged []string
+			log := repository.logger(recordingLogger{&logged})
+
+			before := time.Now().Unix() * 1000
+			classifyReleases(log, "acme", &repository, []GithubRelease{{TagName: "1.0.0", PublishedAt: test.published,
+				Assets: []GithubReleaseAsset{{Name: "plugin.zip", URL: "https://example.com/plugin.zip", CreatedAt: test.created}}}})
+			got := repository.Versions["release"].Date
+			if test.now {
+				if got < before || got > time.Now().Unix()*1000 {
+					t.Errorf("got date %d, want the current time", got)
+				}
+				if len(logged) != 1 || !strings.Contains(logged[0], `unparsable date "yesterday"`) {
+					t.Errorf("logged %q, want the parse failure", logged)
+				}
+				return
+			}
+			if got != test.want.Unix()*1000 {
+				t.Errorf("got date %d, want %d", got, test.want.Unix()*1000)
+			}
+			if len(logged) != 0 {
+				t.Errorf("logged %q, want nothing", logged)
+			}
+		})
+	}
+}
+
+func TestUpdateIntervalConfig(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "update_interval": "100ms", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	var requests int32
+	stubAPI(t, releasePages(&requests, []string{githubRelease("1.0.0", false)}))
+	update := func() string {
+		r := httptest.NewRequest("GET", "/update", nil)
+		r.Header.Set("Authorization", "Bearer s3cret")
+		return serve(r).Body.String()
+	}
+
+	if body := update(); !strings.Contains(body, `"updated":true`) {
+		t.Fatalf("got %s, want the first update to run", body)
+	}
+	if body := update(); !strings.Contains(body, "too recently") {
+		t.Errorf("got %s, want the second update throttled", body)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if body := update(); !strings.Contains(body, `"updated":true`) {
+		t.Errorf("got %s, want an update once the interval passed", body)
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 2 {
+		t.Errorf("got %d release listings, want 2", requests)
+	}
+
+	for _, config := range []string{`{"update_interval": "5 minutes"}`, `{"update_interval": 300}`} {
+		if err := applyConfig([]byte(config)); err == nil {
+			t.Errorf("%s was accepted", config)
+		}
+		if UpdateInterval != 100*time.Millisecond {
+			t.Errorf("got the interval %s after %s, want 100ms kept", UpdateInterval, config)
+		}
+	}
+	if err := applyConfig([]byte(`{}`)); err != nil || UpdateInterval != defaultUpdateInterval {
+		t.Errorf("got the interval %s without the setting, want %s: %v", UpdateInterval, defaultUpdateInterval, err)
+	}
+}
+
+func TestCronUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "anonymous", status: http.StatusForbidden},
+		{name: "cron header from outside", headers: map[string]string{"X-Appengine-Cron": "true"}, status: http.StatusForbidden},
+		{name: "admin", headers: map[string]string{"Authorization": "Bearer s3cret"}, status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			repositories[0].Repositories[0].lastFetch = time.Now()
+			var requests int32
+			stubAPI(t, releasePages(&requests, []string{githubRelease("1.0.0", false)}))
+
+			r := httptest.NewRequest("GET", "/cron/update", nil)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			w := serve(r)
+			if w.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			// The cron update doesn't wait for the update interval.
+			if requests := atomic.LoadInt32(&requests); (requests == 1) != (test.status == http.StatusOK) {
+				t.Errorf("got %d release listings for status %d", requests, w.Code)
+			}
+		})
+	}
+}
+
+func TestUpdateResult(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [
+		{"id": "com.acme.plugin", "name": "plugin"}, {"id": "com.acme.gone", "name": "gone"}]}]}`)
+	var requests int32
+	pages := releasePages(&requests, []string{githubRelease("1.1.0-beta", true), githubRelease("1.0.0", false)})
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/repos/acme/gone") {
+			http.NotFound(w, r)
+			return
+		}
+		pages(w, r)
+	})
+
+	r := httptest.NewRequest("GET", "/update", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	w := serve(r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got status %d and Content-Type %q, want 200 and JSON", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var result struct {
+		Message      string `json:"message"`
+		Repositories []struct {
+			Owner      string            `json:"owner"`
+			Repository string            `json:"repository"`
+			Updated    bool              `json:"updated"`
+			Tags       map[string]string `json:"tags"`
+			Error      string            `json:"error"`
+		} `json:"repositories"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if len(result.Repositories) != 2 {
+		t.Fatalf("got %s, want an entry per repository", w.Body)
+	}
+	plugin, gone := result.Repositories[0], result.Repositories[1]
+	if plugin.Owner != "acme" || plugin.Repository != "plugin" || !plugin.Updated || plugin.Error != "" ||
+		fmt.Sprint(plugin.Tags) != "map[beta:1.1.0-beta release:1.0.0]" {
+		t.Errorf("got %+v for acme/plugin, want it updated with its tags", plugin)
+	}
+	if gone.Repository != "gone" || gone.Updated || len(gone.Tags) != 0 || !strings.Contains(gone.Error, "404") {
+		t.Errorf("got %+v for acme/gone, want the 404", gone)
+	}
+}
+
+func TestRepositoryUpdateHandler(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [
+		{"id": "com.acme.plugin", "name": "plugin"}, {"id": "com.acme.other", "name": "other"}]}]}`)
+	var requests int32
+	pages := releasePages(&requests, []string{githubRelease("1.0.0", false)})
+	var others int32
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/repos/acme/other") {
+			atomic.AddInt32(&others, 1)
+		}
+		pages(w, r)
+	})
+	update := func(url string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Authorization", "Bearer s3cret")
+		return serve(r)
+	}
+
+	w := update("/acme/plugin/update")
+	var status UpdateStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if w.Code != http.StatusOK || len(status.Repositories) != 1 || status.Repositories[0].Tags["release"] != "1.0.0" {
+		t.Errorf("got status %d and %s, want acme/plugin updated to 1.0.0", w.Code, w.Body)
+	}
+	if others := atomic.LoadInt32(&others); others != 0 {
+		t.Errorf("got %d requests for acme/other, want none", others)
+	}
+
+	if w := update("/acme/plugin/update"); !strings.Contains(w.Body.String(), "too recently") {
+		t.Errorf("got %s, want the second update throttled", w.Body)
+	}
+	if w := update("/acme/unknown/update"); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown repository, want 404", w.Code)
+	}
+}
+
+func TestInjectedTransport(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	canned := map[string]string{
+		"GET /repos/acme/plugin":          `{"stargazers_count": 10}`,
+		"GET /repos/acme/plugin/releases": "[" + githubRelease("1.0.0", false) + "]",
+		"GET /search/issues":              `{"items": []}`,
+		"POST /repos/acme/plugin/issues":  `{"html_url": "https://github.com/acme/plugin/issues/1", "number": 1}`,
+	}
+	var requested []string
+	factory := httpClientFactory
+	httpClientFactory = func(r *http.Request) *http.Client {
+		return &http.Client{Transport: roundTripFunc(func(request *http.Request) (*http.Response, error) {
+			key := request.Method + " " + request.URL.Path
+			requested = append(requested, request.URL.Host+" "+key)
+			body, ok := canned[key]
+			status := http.StatusOK
+			if !ok {
+				status = http.StatusNotFound
+			} else if request.Method == "POST" {
+				status = http.StatusCreated
+			}
+			return &http.Response{
+				StatusCode: status,
+				Status:     http.StatusText(status),
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       ioutil.NopCloser(strings.NewReader(body)),
+				Request:    request,
+			}, nil
+		})}
+	}
+	t.Cleanup(func() { httpClientFactory = factory })
+
+	r := httptest.NewRequest("GET", "/update", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("updating: got status %d: %s", w.Code, w.Body)
+	}
+	if w := serve(httptest.NewRequest("GET", "/acme/plugin/release.xml", nil)); !strings.Contains(w.Body.String(), "<version>1.0.0</version>") {
+		t.Errorf("got %s, want the canned release", w.Body)
+	}
+
+	w := serve(httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(`{"body": "NullPointerException"}`)))
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), "https://github.com/acme/plugin/issues/1") {
+		t.Errorf("got status %d and %s, want the canned issue", w.Code, w.Body)
+	}
+
+	for _, request := range requested {
+		if !strings.HasPrefix(request, "api.github.com ") {
+			t.Errorf("requested %s, want only the GitHub API", request)
+		}
+	}
+}
+
+// varies reports whether header lists name in Vary.
+func varies(header http.Header, name string) bool {
+	for _, value := range header["Vary"] {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestFormatNegotiation(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		accept string
+		want   string
+		vary   bool
+	}{
+		{name: "no Accept", url: "/acme/plugin/release", want: "application/json", vary: true},
+		{name: "XML", url: "/acme/plugin/release", accept: "application/xml", want: "application/xml", vary: true},
+		{name: "text XML", url: "/acme/plugin/release/idea", accept: "text/xml", want: "application/xml", vary: true},
+		{name: "JSON", url: "/acme/plugin/release/idea", accept: "application/json", want: "application/json", vary: true},
+		{name: "quality", url: "/acme/plugin/release", accept: "application/json;q=0.5, application/xml;q=0.9", want: "application/xml", vary: true},
+		{name: "unknown", url: "/acme/plugin/release", accept: "text/plain", want: "application/json", vary: true},
+		{name: "ambiguous extension", url: "/acme/plugin/release.txt", accept: "application/xml", want: "application/xml", vary: true},
+		{name: "update plugins", url: "/acme/plugin/release/updatePlugins", accept: "application/xml", want: "application/xml", vary: true},
+		{name: "xml extension", url: "/acme/plugin/release.xml", accept: "application/json", want: "application/xml"},
+		{name: "json extension", url: "/acme/plugin/release.json", accept: "application/xml", want: "application/json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			}
+			invalidateCompressedCache()
+
+			r := httptest.NewRequest("GET", test.url, nil)
+			if test.accept != "" {
+				r.Header.Set("Accept", test.accept)
+			}
+			w := serve(r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != test.want {
+				t.Errorf("got Content-Type %q, want %q", got, test.want)
+			}
+			if test.want == "application/xml" && !strings.HasPrefix(w.Body.String(), "<?xml") {
+				t.Errorf("got %s, want XML", w.Body)
+			}
+			if test.want == "application/json" && !json.Valid(w.Body.Bytes()) {
+				t.Errorf("got %s, want JSON", w.Body)
+			}
+			if vary := varies(w.Header(), "Accept"); vary != test.vary {
+				t.Errorf("got Vary %q, want Accept %v", w.Header()["Vary"], test.vary)
+			}
+		})
+	}
+}
+
+func TestPluginCategory(t *testing.T) {
+	tests := []struct {
+		name     string
+		category string
+		want     string
+	}{
+		{name: "default", want: "Custom Languages"},
+		{name: "configured", category: `, "category": "Tools & Utilities"`, want: "Tools & Utilities"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"`+test.category+`}]}]}`)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			}
+
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/release.xml", nil))
+			var plugin struct {
+				Ff       string `xml:"ff"`
+				Category struct {
+					Name string `xml:"name,attr"`
+				} `xml:"category"`
+			}
+			if err := xml.Unmarshal(w.Body.Bytes(), &plugin); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if plugin.Category.Name != test.want || plugin.Ff != strconv.Quote(test.want) {
+				t.Errorf("got category %q and ff %s, want %q", plugin.Category.Name, plugin.Ff, test.want)
+			}
+		})
+	}
+}
+
+func TestFetchStatusHandling(t *testing.T) {
+	tests := []struct {
+		status int
+		level  string
+		want   string
+	}{
+		{status: http.StatusNotFound, level: "ERROR", want: "acme/plugin: repository not found, check the configured owner and name"},
+		{status: http.StatusUnauthorized, level: "ERROR", want: "acme/plugin: credentials rejected, check the configured OAuth token"},
+		{status: http.StatusForbidden, level: "WARNING", want: "acme/plugin: access denied or rate limited (status 403)"},
+		{status: http.StatusUnprocessableEntity, level: "ERROR", want: "acme/plugin: fetching releases failed"},
+		{status: http.StatusBadGateway, level: "WARNING", want: "acme/plugin: server error 502, retrying on the next update"},
+	}
+
+	messages := map[string]int{}
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.status), func(t *testing.T) {
+			useConfig(t, `{"adminToken": "s3cret", "fetchAttempts": 1, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, http.StatusText(test.status), test.status)
+			})
+			logged := captureLog(t)
+
+			r := httptest.NewRequest("GET", "/cron/update", nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			serve(r)
+
+			logs := false
+			for _, line := range strings.Split(logged.String(), "\n") {
+				logs = logs || strings.Contains(line, test.level+": ") && strings.Contains(line, test.want)
+			}
+			if !logs {
+				t.Errorf("the log is missing the %s %q:\n%s", test.level, test.want, logged)
+			}
+			messages[test.want]++
+
+			var stats []RepositoryStats
+			w := serve(httptest.NewRequest("GET", "/stats", nil))
+			if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || len(stats) != 1 {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if stats[0].LastStatus != test.status {
+				t.Errorf("got the last status %d, want %d", stats[0].LastStatus, test.status)
+			}
+		})
+	}
+	if len(messages) != len(tests) {
+		t.Errorf("got %d distinct messages for %d statuses", len(messages), len(tests))
+	}
+}
+
+func TestPrettyOutput(t *testing.T) {
+	tests := []struct {
+		url      string
+		indented bool
+	}{
+		{url: "/acme/plugin/release.json", indented: true},
+		{url: "/acme/plugin/release.json?pretty=false", indented: false},
+		{url: "/acme/plugin/release.xml", indented: true},
+		{url: "/acme/plugin/release.xml?pretty=false", indented: false},
+		{url: "/acme/plugin/release.xml?pretty=0", indented: false},
+		{url: "/acme/plugin/release.xml?pretty=maybe", indented: true},
+		{url: "/", indented: false},
+		{url: "/?pretty=true", indented: true},
+		{url: "/?pretty=false", indented: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			}
+			invalidateCompressedCache()
+
+			w := serve(httptest.NewRequest("GET", test.url, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", w.Code, w.Body)
+			}
+			body := strings.TrimPrefix(w.Body.String(), xml.Header)
+			if indented := strings.Contains(body, "\n    "); indented != test.indented {
+				t.Errorf("got indented=%v, want %v: %s", indented, test.indented, body)
+			}
+			if !test.indented && strings.Contains(strings.TrimSpace(body), "\n") {
+				t.Errorf("the compact output has line breaks: %s", body)
+			}
+		})
+	}
+}
+
+func TestAssetChecksum(t *testing.T) {
+	const digest = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	tests := []struct {
+		name   string
+		digest string
+	}{
+		{name: "digest", digest: digest},
+		{name: "no digest"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			release := fmt.Sprintf(`{"name": "1.0.0", "tag_name": "1.0.0", "published_at": "2016-01-02T15:04:05Z",
+				"assets": [{"name": "plugin-1.0.0.zip", "browser_download_url": "https://example.com/plugin-1.0.0.zip", "digest": %q}]}`, test.digest)
+			var requests int32
+			stubAPI(t, releasePages(&requests, []string{release}))
+
+			r := httptest.NewRequest("GET", "/cron/update", nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			if w := serve(r); w.Code != http.StatusOK {
+				t.Fatalf("updating: got status %d: %s", w.Code, w.Body)
+			}
+
+			for _, url := range []string{"/acme/plugin/release.xml", "/acme/plugin/release/updatePlugins.xml", "/acme/plugin/release.json"} {
+				body := serve(httptest.NewRequest("GET", url, nil)).Body.String()
+				want := fmt.Sprintf(`checksum="%s"`, test.digest)
+				if strings.HasSuffix(url, ".json") {
+					want = fmt.Sprintf(`"Checksum": %q`, test.digest)
+				}
+				if test.digest == "" {
+					if strings.Contains(strings.ToLower(body), "checksum") {
+						t.Errorf("%s has a checksum without a digest: %s", url, body)
+					}
+				} else if !strings.Contains(body, want) {
+					t.Errorf("%s is missing %s: %s", url, want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{name: "default", want: defaultUserAgent},
+		{name: "configured", config: `"userAgent": "Acme plugin feed (ops@example.com)", `, want: "Acme plugin feed (ops@example.com)"},
+		{name: "blank", config: `"userAgent": "  ", `, want: defaultUserAgent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"adminToken": "s3cret", `+test.config+`"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			var lock sync.Mutex
+			agents := map[string]string{}
+			var requests int32
+			releases := releasePages(&requests, []string{githubRelease("1.0.0", false)})
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				agents[r.Method+" "+r.URL.Path] = r.UserAgent()
+				lock.Unlock()
+				switch r.URL.Path {
+				case "/search/issues":
+					fmt.Fprint(w, `{"items": []}`)
+				case "/repos/acme/plugin/issues":
+					w.WriteHeader(http.StatusCreated)
+					fmt.Fprint(w, `{"html_url": "https://github.com/acme/plugin/issues/1", "number": 1}`)
+				default:
+					releases(w, r)
+				}
+			})
+
+			r := httptest.NewRequest("GET", "/cron/update", nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			r.Header.Set("User-Agent", "inbound client")
+			serve(r)
+			r = httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(`{"body": "NullPointerException"}`))
+			r.Header.Set("User-Agent", "inbound client")
+			serve(r)
+
+			for _, request := range []string{"GET /repos/acme/plugin/releases", "GET /repos/acme/plugin", "GET /search/issues", "POST /repos/acme/plugin/issues"} {
+				if agent, ok := agents[request]; !ok || agent != test.want {
+					t.Errorf("%s: got User-Agent %q, want %q", request, agent, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestChannelIdeaVersions(t *testing.T) {
+	useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin",
+		"ideaVersion": {"sinceBuild": "233.1"},
+		"channelIdeaVersions": {"alpha": {"sinceBuild": "242.1", "untilBuild": "242.*"}, "beta": {"sinceBuild": "241.1"}}}]}]}`)
+	repositories[0].Repositories[0].Versions = RepositoryVersions{
+		"alpha":   {Name: "1.2.0-alpha", Tag: "1.2.0-alpha", Url: "https://example.com/plugin-1.2.0-alpha.zip"},
+		"beta":    {Name: "1.1.0-beta", Tag: "1.1.0-beta", Url: "https://example.com/plugin-1.1.0-beta.zip"},
+		"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "/acme/plugin/alpha.xml?alpha=true", want: `<idea-version since-build="242.1" until-build="242.*">`},
+		{url: "/acme/plugin/alpha/updatePlugins.xml?alpha=true", want: `<idea-version since-build="242.1" until-build="242.*">`},
+		{url: "/acme/plugin/beta.xml?beta=true", want: `<idea-version since-build="241.1">`},
+		{url: "/acme/plugin/release.xml", want: `<idea-version since-build="233.1">`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			w := serve(httptest.NewRequest("GET", test.url, nil))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), test.want) {
+				t.Errorf("got status %d and %s, want %s", w.Code, w.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestOrganizationVendor(t *testing.T) {
+	const config = `{"organizations": [{"name": "acme", "vendor": {"vendor": "Acme Inc.", "email": "plugins@acme.example", "url": "https://acme.example"},
+		"repositories": [{"id": "com.acme.plugin", "name": "plugin"},
+			{"id": "com.acme.tool", "name": "tool", "vendor": {"vendor": "Tool Team", "email": "tools@acme.example", "url": "https://tools.acme.example"}}]},
+		{"name": "other", "repositories": [{"id": "com.other.plugin", "name": "plugin"}]}]}`
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "inherited", url: "/acme/plugin/release.xml", want: `<vendor email="plugins@acme.example" url="https://acme.example">Acme Inc.</vendor>`},
+		{name: "overridden", url: "/acme/tool/release.xml", want: `<vendor email="tools@acme.example" url="https://tools.acme.example">Tool Team</vendor>`},
+		{name: "no organization vendor", url: "/other/plugin/release.xml", want: `<vendor email="" url=""></vendor>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, config)
+			for oidx := range repositories {
+				for ridx := range repositories[oidx].Repositories {
+					repositories[oidx].Repositories[ridx].Versions = RepositoryVersions{
+						"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+					}
+				}
+			}
+
+			w := serve(httptest.NewRequest("GET", test.url, nil))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), test.want) {
+				t.Errorf("got status %d and %s, want %s", w.Code, w.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestDraftChannel(t *testing.T) {
+	draft := `{"name": "1.1.0", "tag_name": "1.1.0", "draft": true, "published_at": "2016-02-02T15:04:05Z",
+		"assets": [{"name": "plugin-1.1.0.zip", "browser_download_url": "https://example.com/plugin-1.1.0.zip"}]}`
+
+	tests := []struct {
+		name   string
+		config string
+		status int
+	}{
+		{name: "excluded", status: http.StatusNotFound},
+		{name: "included", config: `"include_drafts": true, `, status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"adminToken": "s3cret", `+test.config+`"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			var requests int32
+			stubAPI(t, releasePages(&requests, []string{draft, githubRelease("1.0.0", false)}))
+
+			r := httptest.NewRequest("GET", "/cron/update", nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			if w := serve(r); w.Code != http.StatusOK {
+				t.Fatalf("updating: got status %d: %s", w.Code, w.Body)
+			}
+
+			if body := serve(httptest.NewRequest("GET", "/acme/plugin/release.xml", nil)).Body.String(); !strings.Contains(body, "<version>1.0.0</version>") {
+				t.Errorf("got %s, want the release to stay 1.0.0", body)
+			}
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/draft.xml", nil))
+			if w.Code != test.status {
+				t.Fatalf("got status %d for the draft channel, want %d", w.Code, test.status)
+			}
+			if test.status == http.StatusOK && !strings.Contains(w.Body.String(), "<version>1.1.0</version>") {
+				t.Errorf("got %s, want the draft", w.Body)
+			}
+			if listed := strings.Contains(serve(httptest.NewRequest("GET", "/acme/plugin/channels.json", nil)).Body.String(), `"draft"`); listed != (test.status == http.StatusOK) {
+				t.Errorf("got the draft channel listed %v", listed)
+			}
+		})
+	}
+}
+
+func TestMarshalError(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "/", want: `{"error":"internal server error"}`},
+		{url: "/acme/plugin/release.json", want: "500 internal server error"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			}
+
+			w := serve(httptest.NewRequest("GET", test.url, nil))
+			if w.Code != http.StatusOK || w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+				t.Fatalf("got status %d and Content-Length %q for %d bytes, want 200 and the length", w.Code, w.Header().Get("Content-Length"), w.Body.Len())
+			}
+
+			// JSON has no NaN, so the rating can't be encoded.
+			repositories[0].Repositories[0].Rating = float32(math.NaN())
+			invalidateCompressedCache()
+			w = serve(httptest.NewRequest("GET", test.url, nil))
+			if w.Code != http.StatusInternalServerError || strings.TrimSpace(w.Body.String()) != test.want {
+				t.Errorf("got status %d and %q, want 500 and %q", w.Code, w.Body, test.want)
+			}
+			if w.Header().Get("ETag") != "" {
+				t.Errorf("the error has the ETag %q", w.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestUntilBuild(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		want     string
+	}{
+		{name: "configured", settings: `, "ideaVersion": {"untilBuild": "241.*"}`, want: `until-build="241.*"`},
+		{name: "unset"},
+		{name: "empty", settings: `, "ideaVersion": {"untilBuild": ""}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"`+test.settings+`}]}]}`)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			}
+
+			for _, url := range []string{"/acme/plugin/release.xml", "/acme/plugin/release/updatePlugins.xml", "/acme/plugin/release.json"} {
+				body := serve(httptest.NewRequest("GET", url, nil)).Body.String()
+				if strings.Contains(body, "n/a") {
+					t.Errorf("%s has an n/a placeholder: %s", url, body)
+				}
+				untilBuild := strings.Contains(body, "until-build") || strings.Contains(body, "UntilBuild")
+				switch {
+				case test.want == "" && untilBuild:
+					t.Errorf("%s has an until-build without one configured: %s", url, body)
+				case test.want != "" && strings.HasSuffix(url, ".xml") && !strings.Contains(body, test.want):
+					t.Errorf("%s is missing %s: %s", url, test.want, body)
+				case test.want != "" && strings.HasSuffix(url, ".json") && !strings.Contains(body, `"UntilBuild": "241.*"`):
+					t.Errorf("%s is missing the until-build: %s", url, body)
+				}
+			}
+		})
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		size    uint32
+		decimal string
+		binary  string
+	}{
+		{size: 0, decimal: "0 B", binary: "0 B"},
+		{size: 999, decimal: "999 B", binary: "999 B"},
+		{size: 1000, decimal: "1.0 kB", binary: "1000 B"},
+		{size: 1536, decimal: "1.5 kB", binary: "1.5 KiB"},
+		{size: 12300000, decimal: "12.3 MB", binary: "11.7 MiB"},
+		{size: 3 << 30, decimal: "3.2 GB", binary: "3.0 GiB"},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(int(test.size)), func(t *testing.T) {
+			useConfig(t, `{}`)
+			if got := humanSize(test.size); got != test.decimal {
+				t.Errorf("got %q, want %q", got, test.decimal)
+			}
+			useConfig(t, `{"binarySizes": true}`)
+			if got := humanSize(test.size); got != test.binary {
+				t.Errorf("got %q in binary units, want %q", got, test.binary)
+			}
+		})
+	}
+}
+
+func TestSizeHumanRendered(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{name: "decimal", config: `{`, want: `"SizeHuman":"12.3 MB"`},
+		{name: "binary", config: `{"binarySizes": true, `, want: `"SizeHuman":"11.7 MiB"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config+`"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			// Versions fetched or stored before the setting changed.
+			repositories[0].Repositories[0].Versions = RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip", Size: 12300000, SizeHuman: "stale"},
+			}
+
+			if body := serve(httptest.NewRequest("GET", "/", nil)).Body.String(); !strings.Contains(body, test.want) {
+				t.Errorf("got %s, want %s", body, test.want)
+			}
+			if body := serve(httptest.NewRequest("GET", "/acme/plugin/release.xml", nil)).Body.String(); !strings.Contains(body, `size="12300000"`) || strings.Contains(body, "MB") || strings.Contains(body, "stale") {
+				t.Errorf("got %s, want only the byte count", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package wrigi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	requestCounters     = map[string]*uint64{}
+	requestCountersLock sync.Mutex
+
+	fetchSuccesses uint64
+	fetchFailures  uint64
+	rateLimitHits  uint64
+)
+
+func countRequests(route string, handler http.HandlerFunc) http.HandlerFunc {
+	requestCountersLock.Lock()
+	counter, ok := requestCounters[route]
+	if !ok {
+		counter = new(uint64)
+		requestCounters[route] = counter
+	}
+	requestCountersLock.Unlock()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(counter, 1)
+		handler(w, r)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var out bytes.Buffer
+
+	requestCountersLock.Lock()
+	routes := make([]string, 0, len(requestCounters))
+	for route := range requestCounters {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	fmt.Fprintln(&out, "# HELP wrigi_requests_total Requests served per route.")
+	fmt.Fprintln(&out, "# TYPE wrigi_requests_total counter")
+	for _, route := range routes {
+		fmt.Fprintf(&out, "wrigi_requests_total{route=%q} %d\n", route, atomic.LoadUint64(requestCounters[route]))
+	}
+	requestCountersLock.Unlock()
+
+	fmt.Fprintln(&out, "# HELP wrigi_fetches_total Release fetches by outcome.")
+	fmt.Fprintln(&out, "# TYPE wrigi_fetches_total counter")
+	fmt.Fprintf(&out, "wrigi_fetches_total{result=\"success\"} %d\n", atomic.LoadUint64(&fetchSuccesses))
+	fmt.Fprintf(&out, "wrigi_fetches_total{result=\"failure\"} %d\n", atomic.LoadUint64(&fetchFailures))
+	fmt.Fprintln(&out, "# HELP wrigi_rate_limited_total Fetches skipped or rejected because of rate limiting.")
+	fmt.Fprintln(&out, "# TYPE wrigi_rate_limited_total counter")
+	fmt.Fprintf(&out, "wrigi_rate_limited_total %d\n", atomic.LoadUint64(&rateLimitHits))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(out.Bytes())
+}
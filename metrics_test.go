@@ -0,0 +1,80 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics returns the samples /metrics serves, keyed by name and labels.
+func scrapeMetrics(t *testing.T) map[string]uint64 {
+	t.Helper()
+	w := serve(httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("got status %d and Content-Type %q, want 200 and text", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	samples := map[string]uint64{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		value, err := strconv.ParseUint(line[idx+1:], 10, 64)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", line, err)
+		}
+		samples[line[:idx]] = value
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		url     string
+		counter string
+	}{
+		{name: "request", url: "/version", counter: `wrigi_requests_total{route="/version"}`},
+		{name: "fetch success", status: http.StatusOK, url: "/cron/update", counter: `wrigi_fetches_total{result="success"}`},
+		{name: "fetch failure", status: http.StatusInternalServerError, url: "/cron/update", counter: `wrigi_fetches_total{result="failure"}`},
+		{name: "rate limited", status: http.StatusForbidden, url: "/cron/update", counter: "wrigi_rate_limited_total"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"adminToken": "s3cret", "fetchAttempts": 1, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			var requests int32
+			releases := releasePages(&requests, []string{githubRelease("1.0.0", false)})
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if test.status != http.StatusOK {
+					http.Error(w, http.StatusText(test.status), test.status)
+					return
+				}
+				releases(w, r)
+			})
+
+			before := scrapeMetrics(t)
+			r := httptest.NewRequest("GET", test.url, nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			serve(r)
+			after := scrapeMetrics(t)
+
+			if _, ok := after[test.counter]; !ok {
+				t.Fatalf("/metrics has no %s", test.counter)
+			}
+			if after[test.counter] != before[test.counter]+1 {
+				t.Errorf("%s went from %d to %d, want one more", test.counter, before[test.counter], after[test.counter])
+			}
+		})
+	}
+}
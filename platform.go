@@ -0,0 +1,116 @@
+package wrigi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// appContext is what the handlers need from a request's platform
+	// context. On App Engine it is the request's appengine.Context.
+	appContext interface {
+		Debugf(format string, args ...interface{})
+		Infof(format string, args ...interface{})
+		Warningf(format string, args ...interface{})
+		Errorf(format string, args ...interface{})
+		Criticalf(format string, args ...interface{})
+	}
+
+	// entityKey names a stored entity. A key with neither StringID nor
+	// IntID is incomplete, and the store picks an IntID when it is put.
+	entityKey struct {
+		Kind     string
+		StringID string
+		IntID    int64
+		Parent   *entityKey
+	}
+
+	entityQuery struct {
+		Kind     string
+		Ancestor *entityKey
+		Order    string
+		Limit    int
+	}
+
+	entityStore interface {
+		Get(c appContext, key *entityKey, dst interface{}) error
+		// GetMulti returns a multiError when only some keys failed.
+		GetMulti(c appContext, keys []*entityKey, dst interface{}) error
+		Put(c appContext, key *entityKey, src interface{}) (*entityKey, error)
+		PutMulti(c appContext, keys []*entityKey, src interface{}) ([]*entityKey, error)
+		Delete(c appContext, key *entityKey) error
+		DeleteMulti(c appContext, keys []*entityKey) error
+		GetAll(c appContext, query entityQuery, dst interface{}) ([]*entityKey, error)
+	}
+
+	cacheStore interface {
+		Get(c appContext, key string) ([]byte, error)
+		GetMulti(c appContext, keys []string) (map[string][]byte, error)
+		Set(c appContext, key string, value []byte, expiration time.Duration) error
+		Add(c appContext, key string, value []byte, expiration time.Duration) error
+		Delete(c appContext, key string) error
+		Increment(c appContext, key string, delta int64, initial uint64) (uint64, error)
+		IncrementExisting(c appContext, key string, delta int64) (uint64, error)
+	}
+
+	multiError []error
+
+	// contextWrapper is implemented by the contexts that decorate the
+	// platform context, so that the platform can get it back.
+	contextWrapper interface {
+		unwrap() appContext
+	}
+)
+
+var (
+	// AdminToken is the bearer token administrators send to a standalone
+	// instance. App Engine uses its own administrators instead.
+	AdminToken string
+
+	errNoSuchEntity = errors.New("no such entity")
+	errCacheMiss    = errors.New("cache miss")
+	errNotStored    = errors.New("item not stored")
+)
+
+func (m multiError) Error() string {
+	var messages []string
+	for _, err := range m {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	return fmt.Sprintf("%d errors: %s", len(messages), strings.Join(messages, "; "))
+}
+
+func newKey(kind, id string, parent *entityKey) *entityKey {
+	return &entityKey{Kind: kind, StringID: id, Parent: parent}
+}
+
+func newIncompleteKey(kind string, parent *entityKey) *entityKey {
+	return &entityKey{Kind: kind, Parent: parent}
+}
+
+func (key *entityKey) String() string {
+	id := key.StringID
+	if id == "" {
+		id = fmt.Sprint(key.IntID)
+	}
+	if key.Parent != nil {
+		return key.Parent.String() + "," + key.Kind + ":" + id
+	}
+	return key.Kind + ":" + id
+}
+
+// adminHandler lets through cron requests and administrators only.
+func adminHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cronRequest(r) && !isAdmin(r) {
+			http.Error(w, "403 forbidden", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,201 @@
+//go:build appengine
+// +build appengine
+
+package wrigi
+
+import (
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+	"appengine/user"
+)
+
+type (
+	appengineStore struct{}
+	appengineCache struct{}
+)
+
+var (
+	store entityStore = appengineStore{}
+	cache cacheStore  = appengineCache{}
+)
+
+func platformContext(r *http.Request) appContext {
+	return appengine.NewContext(r)
+}
+
+// engineContext returns the appengine.Context that c decorates.
+func engineContext(c appContext) appengine.Context {
+	for {
+		wrapper, ok := c.(contextWrapper)
+		if !ok {
+			return c.(appengine.Context)
+		}
+		c = wrapper.unwrap()
+	}
+}
+
+func isAdmin(r *http.Request) bool {
+	return user.IsAdmin(appengine.NewContext(r))
+}
+
+// cronRequest reports whether r comes from App Engine's cron service, which
+// strips the header from outside requests.
+func cronRequest(r *http.Request) bool {
+	return r.Header.Get("X-Appengine-Cron") == "true"
+}
+
+func isDevServer() bool {
+	return appengine.IsDevAppServer()
+}
+
+func platformTimeout(err error) bool {
+	return appengine.IsTimeoutError(err)
+}
+
+func engineKey(c appengine.Context, key *entityKey) *datastore.Key {
+	if key == nil {
+		return nil
+	}
+	return datastore.NewKey(c, key.Kind, key.StringID, key.IntID, engineKey(c, key.Parent))
+}
+
+func engineKeys(c appengine.Context, keys []*entityKey) []*datastore.Key {
+	converted := make([]*datastore.Key, len(keys))
+	for idx, key := range keys {
+		converted[idx] = engineKey(c, key)
+	}
+	return converted
+}
+
+func platformKey(key *datastore.Key) *entityKey {
+	if key == nil {
+		return nil
+	}
+	return &entityKey{Kind: key.Kind(), StringID: key.StringID(), IntID: key.IntID(), Parent: platformKey(key.Parent())}
+}
+
+func platformKeys(keys []*datastore.Key) []*entityKey {
+	converted := make([]*entityKey, len(keys))
+	for idx, key := range keys {
+		converted[idx] = platformKey(key)
+	}
+	return converted
+}
+
+func storeError(err error) error {
+	if errs, ok := err.(appengine.MultiError); ok {
+		converted := make(multiError, len(errs))
+		for idx, err := range errs {
+			converted[idx] = storeError(err)
+		}
+		return converted
+	}
+	if err == datastore.ErrNoSuchEntity {
+		return errNoSuchEntity
+	}
+	return err
+}
+
+func (appengineStore) Get(c appContext, key *entityKey, dst interface{}) error {
+	ec := engineContext(c)
+	return storeError(datastore.Get(ec, engineKey(ec, key), dst))
+}
+
+func (appengineStore) GetMulti(c appContext, keys []*entityKey, dst interface{}) error {
+	ec := engineContext(c)
+	return storeError(datastore.GetMulti(ec, engineKeys(ec, keys), dst))
+}
+
+func (appengineStore) Put(c appContext, key *entityKey, src interface{}) (*entityKey, error) {
+	ec := engineContext(c)
+	stored, err := datastore.Put(ec, engineKey(ec, key), src)
+	return platformKey(stored), storeError(err)
+}
+
+func (appengineStore) PutMulti(c appContext, keys []*entityKey, src interface{}) ([]*entityKey, error) {
+	ec := engineContext(c)
+	stored, err := datastore.PutMulti(ec, engineKeys(ec, keys), src)
+	return platformKeys(stored), storeError(err)
+}
+
+func (appengineStore) Delete(c appContext, key *entityKey) error {
+	ec := engineContext(c)
+	return storeError(datastore.Delete(ec, engineKey(ec, key)))
+}
+
+func (appengineStore) DeleteMulti(c appContext, keys []*entityKey) error {
+	ec := engineContext(c)
+	return storeError(datastore.DeleteMulti(ec, engineKeys(ec, keys)))
+}
+
+func (appengineStore) GetAll(c appContext, query entityQuery, dst interface{}) ([]*entityKey, error) {
+	ec := engineContext(c)
+	q := datastore.NewQuery(query.Kind)
+	if query.Ancestor != nil {
+		q = q.Ancestor(engineKey(ec, query.Ancestor))
+	}
+	if query.Order != "" {
+		q = q.Order(query.Order)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+	keys, err := q.GetAll(ec, dst)
+	return platformKeys(keys), storeError(err)
+}
+
+func cacheError(err error) error {
+	switch err {
+	case memcache.ErrCacheMiss:
+		return errCacheMiss
+	case memcache.ErrNotStored:
+		return errNotStored
+	}
+	return err
+}
+
+func (appengineCache) Get(c appContext, key string) ([]byte, error) {
+	item, err := memcache.Get(engineContext(c), key)
+	if err != nil {
+		return nil, cacheError(err)
+	}
+	return item.Value, nil
+}
+
+func (appengineCache) GetMulti(c appContext, keys []string) (map[string][]byte, error) {
+	items, err := memcache.GetMulti(engineContext(c), keys)
+	if err != nil {
+		return nil, cacheError(err)
+	}
+	values := make(map[string][]byte, len(items))
+	for key, item := range items {
+		values[key] = item.Value
+	}
+	return values, nil
+}
+
+func (appengineCache) Set(c appContext, key string, value []byte, expiration time.Duration) error {
+	return cacheError(memcache.Set(engineContext(c), &memcache.Item{Key: key, Value: value, Expiration: expiration}))
+}
+
+func (appengineCache) Add(c appContext, key string, value []byte, expiration time.Duration) error {
+	return cacheError(memcache.Add(engineContext(c), &memcache.Item{Key: key, Value: value, Expiration: expiration}))
+}
+
+func (appengineCache) Delete(c appContext, key string) error {
+	return cacheError(memcache.Delete(engineContext(c), key))
+}
+
+func (appengineCache) Increment(c appContext, key string, delta int64, initial uint64) (uint64, error) {
+	value, err := memcache.Increment(engineContext(c), key, delta, initial)
+	return value, cacheError(err)
+}
+
+func (appengineCache) IncrementExisting(c appContext, key string, delta int64) (uint64, error) {
+	value, err := memcache.IncrementExisting(engineContext(c), key, delta)
+	return value, cacheError(err)
+}
@@ -0,0 +1,321 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// stdLogger sends the log lines to the standard logger.
+	stdLogger struct{}
+
+	memoryEntity struct {
+		key  *entityKey
+		data []byte
+		seq  int64
+	}
+
+	// memoryStore keeps the entities of a standalone instance in memory,
+	// JSON encoded so that callers never share them.
+	memoryStore struct {
+		lock     sync.Mutex
+		entities map[string]memoryEntity
+		seq      int64
+	}
+
+	memoryItem struct {
+		value   []byte
+		expires time.Time
+	}
+
+	memoryCache struct {
+		lock  sync.Mutex
+		items map[string]memoryItem
+	}
+)
+
+var (
+	store entityStore = newMemoryStore()
+	cache cacheStore  = newMemoryCache()
+)
+
+func (stdLogger) Debugf(format string, args ...interface{}) {
+	log.Printf("DEBUG: "+format, args...)
+}
+
+func (stdLogger) Infof(format string, args ...interface{}) {
+	log.Printf("INFO: "+format, args...)
+}
+
+func (stdLogger) Warningf(format string, args ...interface{}) {
+	log.Printf("WARNING: "+format, args...)
+}
+
+func (stdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+func (stdLogger) Criticalf(format string, args ...interface{}) {
+	log.Printf("CRITICAL: "+format, args...)
+}
+
+func platformContext(r *http.Request) appContext {
+	return stdLogger{}
+}
+
+// isAdmin accepts requests that send AdminToken as a bearer token. Without
+// an AdminToken nobody is an administrator.
+func isAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) == 1
+}
+
+// cronRequest is always false: a standalone scheduler authenticates as an
+// administrator.
+func cronRequest(r *http.Request) bool {
+	return false
+}
+
+func isDevServer() bool {
+	return false
+}
+
+func platformTimeout(err error) bool {
+	return err == context.DeadlineExceeded
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{entities: map[string]memoryEntity{}}
+}
+
+func (s *memoryStore) Get(c appContext, key *entityKey, dst interface{}) error {
+	s.lock.Lock()
+	entity, ok := s.entities[key.String()]
+	s.lock.Unlock()
+	if !ok {
+		return errNoSuchEntity
+	}
+	return json.Unmarshal(entity.data, dst)
+}
+
+func (s *memoryStore) GetMulti(c appContext, keys []*entityKey, dst interface{}) error {
+	values := reflect.ValueOf(dst)
+	errs := make(multiError, len(keys))
+	failed := false
+	for idx, key := range keys {
+		if errs[idx] = s.Get(c, key, values.Index(idx).Addr().Interface()); errs[idx] != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+func (s *memoryStore) Put(c appContext, key *entityKey, src interface{}) (*entityKey, error) {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	stored := *key
+	if stored.StringID == "" && stored.IntID == 0 {
+		stored.IntID = s.seq
+	}
+	id := stored.String()
+	seq := s.seq
+	if existing, ok := s.entities[id]; ok {
+		seq = existing.seq
+	}
+	s.entities[id] = memoryEntity{key: &stored, data: data, seq: seq}
+	return &stored, nil
+}
+
+func (s *memoryStore) PutMulti(c appContext, keys []*entityKey, src interface{}) ([]*entityKey, error) {
+	values := reflect.ValueOf(src)
+	stored := make([]*entityKey, len(keys))
+	for idx, key := range keys {
+		var err error
+		if stored[idx], err = s.Put(c, key, values.Index(idx).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+func (s *memoryStore) Delete(c appContext, key *entityKey) error {
+	s.lock.Lock()
+	delete(s.entities, key.String())
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *memoryStore) DeleteMulti(c appContext, keys []*entityKey) error {
+	for _, key := range keys {
+		s.Delete(c, key)
+	}
+	return nil
+}
+
+// GetAll ignores query.Order: entities come back in the order they were
+// first stored.
+func (s *memoryStore) GetAll(c appContext, query entityQuery, dst interface{}) ([]*entityKey, error) {
+	s.lock.Lock()
+	var matches []memoryEntity
+	for _, entity := range s.entities {
+		if entity.key.Kind == query.Kind && (query.Ancestor == nil || hasAncestor(entity.key, query.Ancestor)) {
+			matches = append(matches, entity)
+		}
+	}
+	s.lock.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].seq < matches[j].seq })
+	if query.Limit > 0 && len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+	}
+
+	values := reflect.ValueOf(dst).Elem()
+	keys := make([]*entityKey, len(matches))
+	for idx, entity := range matches {
+		value := reflect.New(values.Type().Elem())
+		if err := json.Unmarshal(entity.data, value.Interface()); err != nil {
+			return nil, err
+		}
+		values.Set(reflect.Append(values, value.Elem()))
+		keys[idx] = entity.key
+	}
+	return keys, nil
+}
+
+func hasAncestor(key, ancestor *entityKey) bool {
+	for parent := key.Parent; parent != nil; parent = parent.Parent {
+		if parent.String() == ancestor.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{items: map[string]memoryItem{}}
+}
+
+// item returns the live item stored under key. It must be called with the
+// lock held.
+func (m *memoryCache) item(key string) (memoryItem, bool) {
+	item, ok := m.items[key]
+	if ok && !item.expires.IsZero() && time.Now().After(item.expires) {
+		delete(m.items, key)
+		return memoryItem{}, false
+	}
+	return item, ok
+}
+
+func (m *memoryCache) Get(c appContext, key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	item, ok := m.item(key)
+	if !ok {
+		return nil, errCacheMiss
+	}
+	return item.value, nil
+}
+
+func (m *memoryCache) GetMulti(c appContext, keys []string) (map[string][]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	values := map[string][]byte{}
+	for _, key := range keys {
+		if item, ok := m.item(key); ok {
+			values[key] = item.value
+		}
+	}
+	return values, nil
+}
+
+func expiry(expiration time.Duration) time.Time {
+	if expiration <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(expiration)
+}
+
+func (m *memoryCache) Set(c appContext, key string, value []byte, expiration time.Duration) error {
+	m.lock.Lock()
+	m.items[key] = memoryItem{value: value, expires: expiry(expiration)}
+	m.lock.Unlock()
+	return nil
+}
+
+func (m *memoryCache) Add(c appContext, key string, value []byte, expiration time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.item(key); ok {
+		return errNotStored
+	}
+	m.items[key] = memoryItem{value: value, expires: expiry(expiration)}
+	return nil
+}
+
+func (m *memoryCache) Delete(c appContext, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.item(key); !ok {
+		return errCacheMiss
+	}
+	delete(m.items, key)
+	return nil
+}
+
+// increment adds delta to the number stored under key, stopping at zero
+// like memcache does. It must be called with the lock held.
+func (m *memoryCache) increment(key string, item memoryItem, delta int64) (uint64, error) {
+	value, err := strconv.ParseUint(string(item.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if delta < 0 && uint64(-delta) > value {
+		value = 0
+	} else {
+		value += uint64(delta)
+	}
+	item.value = []byte(strconv.FormatUint(value, 10))
+	m.items[key] = item
+	return value, nil
+}
+
+func (m *memoryCache) Increment(c appContext, key string, delta int64, initial uint64) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	item, ok := m.item(key)
+	if !ok {
+		item = memoryItem{value: []byte(strconv.FormatUint(initial, 10))}
+	}
+	return m.increment(key, item, delta)
+}
+
+func (m *memoryCache) IncrementExisting(c appContext, key string, delta int64) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	item, ok := m.item(key)
+	if !ok {
+		return 0, errCacheMiss
+	}
+	return m.increment(key, item, delta)
+}
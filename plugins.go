@@ -0,0 +1,167 @@
+package wrigi
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/russross/blackfriday"
+)
+
+type (
+	CDATA string
+
+	UpdateIdeaVersion struct {
+		SinceBuild string `xml:"since-build,attr"`
+		UntilBuild string `xml:"until-build,attr,omitempty"`
+	}
+
+	UpdatePlugin struct {
+		ID          string            `xml:"id,attr"`
+		Url         string            `xml:"url,attr"`
+		Version     string            `xml:"version,attr"`
+		Checksum    string            `xml:"checksum,attr,omitempty"`
+		IdeaVersion UpdateIdeaVersion `xml:"idea-version"`
+		Name        string            `xml:"name"`
+		Description string            `xml:"description"`
+		ChangeNotes CDATA             `xml:"change-notes"`
+	}
+
+	UpdatePlugins struct {
+		Plugins []UpdatePlugin `xml:"plugin"`
+		XMLName struct{}       `xml:"plugins" json:"-"`
+	}
+)
+
+func (c CDATA) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Text string `xml:",cdata"`
+	}{string(c)}, start)
+}
+
+var ChangeNotesMaxLength = 4000
+
+// truncateNotes shortens the markdown body to ChangeNotesMaxLength
+// characters, and links to the full notes. It cuts at the last paragraph
+// break before the limit, or else the last line break or whitespace, and
+// closes a code block left open by the cut.
+func truncateNotes(body, url string) string {
+	if ChangeNotesMaxLength <= 0 || utf8.RuneCountInString(body) <= ChangeNotesMaxLength {
+		return body
+	}
+
+	limit, runes := len(body), 0
+	for idx := range body {
+		if runes == ChangeNotesMaxLength {
+			limit = idx
+			break
+		}
+		runes++
+	}
+	head := body[:limit]
+
+	cut := strings.LastIndex(head, "\n\n")
+	if cut <= 0 {
+		cut = strings.LastIndex(head, "\n")
+	}
+	if cut <= 0 {
+		cut = strings.LastIndexAny(head, " \t\r")
+	}
+	if cut <= 0 {
+		cut = limit
+	}
+	notes := strings.TrimSpace(body[:cut])
+	if strings.Count(notes, "```")%2 == 1 {
+		notes += "\n```"
+	}
+	return fmt.Sprintf("%s\n\n…\n\n<a href=\"%s\">Read the full release notes</a>", notes, html.EscapeString(url))
+}
+
+func changeNotes(body string) (notes CDATA) {
+	defer func() {
+		if recover() != nil {
+			notes = CDATA(body)
+		}
+	}()
+	return CDATA(blackfriday.MarkdownCommon([]byte(body)))
+}
+
+func updatePlugin(plugin IdeaPlugin) UpdatePlugin {
+	return UpdatePlugin{
+		ID:       plugin.ID,
+		Url:      plugin.DownloadUrl,
+		Version:  plugin.Version,
+		Checksum: plugin.Checksum,
+		IdeaVersion: UpdateIdeaVersion{
+			SinceBuild: plugin.IdeaVersion.SinceBuild,
+			UntilBuild: plugin.IdeaVersion.UntilBuild,
+		},
+		Name:        plugin.Name,
+		Description: plugin.Description,
+		ChangeNotes: plugin.ChangeNotes,
+	}
+}
+
+func updatePluginsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	owner, repository, ok := lookupRepository(vars["owner"], vars["repository"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	ideaPlugin, ok := channelPlugin(w, r, owner, repository, vars["channel"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	plugins := UpdatePlugins{
+		Plugins: []UpdatePlugin{updatePlugin(ideaPlugin)},
+	}
+
+	format := negotiateFormat(r, vars["format"])
+	if format != vars["format"] {
+		w.Header().Add("Vary", "Accept")
+	}
+	response, err := marshalFormat(w, r, format, plugins)
+	if err != nil {
+		newContext(r).Errorf("encoding %s: %v", r.URL.Path, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	key := repositoryKey(vars["owner"], vars["repository"]) + "/" + vars["channel"] + "/updatePlugins." + format
+	writeCompressed(w, r, key, response)
+}
+
+func allPluginsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	owner, repository, ok := lookupRepository(vars["owner"], vars["repository"])
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	plugins := UpdatePlugins{}
+	for _, channel := range repository.listedChannels(r) {
+		if ideaPlugin, ok := channelPlugin(w, r, owner, repository, channel); ok {
+			plugins.Plugins = append(plugins.Plugins, updatePlugin(ideaPlugin))
+		}
+	}
+
+	response, err := marshalFormat(w, r, "xml", plugins)
+	if err != nil {
+		newContext(r).Errorf("encoding %s: %v", r.URL.Path, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeCompressed(w, r, repositoryKey(vars["owner"], vars["repository"])+"/plugins.xml", response)
+}
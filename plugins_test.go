@@ -0,0 +1,148 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTruncateNotes(t *testing.T) {
+	const more = "\n\n…\n\n<a href=\"https://example.com/notes?a=1&amp;b=2\">Read the full release notes</a>"
+
+	tests := []struct {
+		name  string
+		limit int
+		body  string
+		want  string
+	}{
+		{name: "short", limit: 20, body: "short notes", want: "short notes"},
+		{name: "disabled", limit: 0, body: "notes that are far too long", want: "notes that are far too long"},
+		{name: "paragraph break", limit: 25, body: "first paragraph\n\nsecond paragraph", want: "first paragraph" + more},
+		{name: "line break", limit: 15, body: "line one\nline two is long", want: "line one" + more},
+		{name: "whitespace", limit: 12, body: "word word word", want: "word word" + more},
+		{name: "characters, not bytes", limit: 8, body: "ééééé ééééé", want: "ééééé" + more},
+		{name: "no whitespace", limit: 4, body: "abcdefghij", want: "abcd" + more},
+		{name: "open code block", limit: 15, body: "```\ncode line\nmore code\n```", want: "```\ncode line\n```" + more},
+	}
+
+	limit := ChangeNotesMaxLength
+	t.Cleanup(func() { ChangeNotesMaxLength = limit })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ChangeNotesMaxLength = test.limit
+			if got := truncateNotes(test.body, "https://example.com/notes?a=1&b=2"); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdatePluginsHandler(t *testing.T) {
+	useConfig(t, `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin", "pluginName": "Acme", "description": "Acme support",
+		"ideaVersion": {"sinceBuild": "233.1", "untilBuild": "241.*"}}]}]}`)
+	repositories[0].Repositories[0].Versions = RepositoryVersions{
+		"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip", Body: "Fixes"},
+	}
+
+	const want = `<?xml version="1.0" encoding="UTF-8"?>
+<plugins>
+    <plugin id="com.acme.plugin.release" url="https://example.com/plugin-1.0.0.zip" version="1.0.0">
+        <idea-version since-build="233.1" until-build="241.*"></idea-version>
+        <name>Acme</name>
+        <description>Acme support</description>
+        <change-notes><![CDATA[<p>Fixes</p>
+]]></change-notes>
+    </plugin>
+</plugins>`
+
+	for _, url := range []string{"/acme/plugin/release/updatePlugins.xml", "/acme/plugin/release/updatePlugins"} {
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Accept", "application/xml")
+		w := serve(r)
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: got status %d and\n%s\nwant\n%s", url, w.Code, w.Body, want)
+		}
+	}
+	if w := serve(httptest.NewRequest("GET", "/acme/plugin/nightly/updatePlugins.xml", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown channel, want 404", w.Code)
+	}
+}
+
+func TestAllPluginsHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions RepositoryVersions
+		want     []string
+	}{
+		{
+			name: "all channels",
+			versions: RepositoryVersions{
+				"alpha":   {Name: "1.2.0-alpha", Tag: "1.2.0-alpha", Url: "https://example.com/plugin-1.2.0-alpha.zip"},
+				"beta":    {Name: "1.1.0-beta", Tag: "1.1.0-beta", Url: "https://example.com/plugin-1.1.0-beta.zip"},
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			},
+			want: []string{"com.acme.plugin.release", "com.acme.plugin.beta", "com.acme.plugin.alpha"},
+		},
+		{
+			name: "empty channels skipped",
+			versions: RepositoryVersions{
+				"release": {Name: "1.0.0", Tag: "1.0.0", Url: "https://example.com/plugin-1.0.0.zip"},
+			},
+			want: []string{"com.acme.plugin.release"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			repositories[0].Repositories[0].Versions = test.versions
+
+			w := serve(httptest.NewRequest("GET", "/acme/plugin/plugins.xml", nil))
+			var plugins struct {
+				Plugins []struct {
+					ID string `xml:"id,attr"`
+				} `xml:"plugin"`
+			}
+			if err := xml.Unmarshal(w.Body.Bytes(), &plugins); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			var got []string
+			for _, plugin := range plugins.Plugins {
+				got = append(got, plugin.ID)
+			}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("got plugins %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChangeNotes(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "list and link",
+			body: "## Fixes\n\n* one\n* two, see [the issue](https://github.com/acme/plugin/issues/1)\n",
+			want: "<h2>Fixes</h2>\n\n<ul>\n<li>one</li>\n<li>two, see <a href=\"https://github.com/acme/plugin/issues/1\">the issue</a></li>\n</ul>\n",
+		},
+		{name: "plain", body: "Fixes", want: "<p>Fixes</p>\n"},
+		{name: "empty", body: "", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := changeNotes(test.body); string(got) != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package wrigi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RateLimit struct {
+	Limited   bool      `json:"limited"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+var (
+	OAuthTokens   []string
+	rateLimits    = map[string]RateLimit{}
+	nextTokenIdx  int
+	rateLimitLock sync.Mutex
+
+	errRateLimited = errors.New("GitHub rate limit exceeded")
+)
+
+func (limit RateLimit) exhausted(now time.Time) bool {
+	return limit.Remaining == 0 && now.Before(limit.Reset)
+}
+
+// RateLimitStatus reports the combined limit of all tokens; it is only
+// limited once every token is.
+func RateLimitStatus() RateLimit {
+	tokens := OAuthTokens
+	if githubAppConfigured() {
+		tokens = []string{currentAppToken()}
+	}
+	if len(tokens) == 0 {
+		tokens = []string{""}
+	}
+
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+
+	now := time.Now()
+	status := RateLimit{Remaining: -1, Limited: true}
+	for _, token := range tokens {
+		limit, ok := rateLimits[token]
+		if !ok {
+			status.Limited = false
+			continue
+		}
+		if limit.exhausted(now) {
+			if status.Reset.IsZero() || limit.Reset.Before(status.Reset) {
+				status.Reset = limit.Reset
+			}
+			continue
+		}
+		status.Limited = false
+		if status.Remaining < 0 {
+			status.Remaining = 0
+		}
+		status.Remaining += limit.Remaining
+	}
+	if status.Limited {
+		status.Remaining = 0
+	}
+	return status
+}
+
+func rateLimited() bool {
+	return RateLimitStatus().Limited
+}
+
+// authorize sets the Authorization header of request to the GitHub App
+// installation token when an App is configured, and otherwise to the next
+// token in OAuthTokens that isn't rate limited.
+func authorize(client *http.Client, request *http.Request) {
+	if githubAppConfigured() {
+		if token, err := installationToken(client); err == nil {
+			request.Header.Set("Authorization", "token "+token)
+			return
+		}
+	}
+
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+
+	if len(OAuthTokens) == 0 {
+		return
+	}
+
+	now := time.Now()
+	token := OAuthTokens[nextTokenIdx%len(OAuthTokens)]
+	for i := 0; i < len(OAuthTokens); i++ {
+		candidate := OAuthTokens[(nextTokenIdx+i)%len(OAuthTokens)]
+		if !rateLimits[candidate].exhausted(now) {
+			token = candidate
+			nextTokenIdx += i
+			break
+		}
+	}
+	nextTokenIdx = (nextTokenIdx + 1) % len(OAuthTokens)
+	request.Header.Set("Authorization", "token "+token)
+}
+
+func recordRateLimit(request *http.Request, header http.Header) (RateLimit, bool) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+
+	token := strings.TrimPrefix(request.Header.Get("Authorization"), "token ")
+	limit := RateLimit{Remaining: remaining, Reset: time.Unix(reset, 0)}
+	limit.Limited = limit.exhausted(time.Now())
+
+	rateLimitLock.Lock()
+	rateLimits[token] = limit
+	rateLimitLock.Unlock()
+
+	return limit, true
+}
@@ -0,0 +1,134 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitBackoff(t *testing.T) {
+	useConfig(t, `{"oauth": "t0ken", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	var requests int32
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"message": "API rate limit exceeded"}`)
+	})
+	r := httptest.NewRequest("GET", "/update", nil)
+
+	if _, err := updateRepository(r, "acme", repositories[0].Repositories[0]); err == nil {
+		t.Fatal("the rate limited fetch succeeded")
+	}
+	status := RateLimitStatus()
+	if !status.Limited || status.Remaining != 0 || !status.Reset.Equal(reset) {
+		t.Errorf("got %+v, want limited until %s", status, reset)
+	}
+
+	if _, err := updateRepository(r, "acme", repositories[0].Repositories[0]); err != errRateLimited {
+		t.Errorf("got error %v, want %v", err, errRateLimited)
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 1 {
+		t.Errorf("got %d requests, want only the first fetch", requests)
+	}
+}
+
+func TestReleaseRequestAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{name: "token", config: `"oauth": "t0ken", `, want: "token t0ken"},
+		{name: "no token", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("WRIGI_OAUTH_TOKEN", "")
+			useConfig(t, `{`+test.config+`"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			var authorization atomic.Value
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/acme/plugin/releases" {
+					fmt.Fprint(w, `{"stargazers_count": 1}`)
+					return
+				}
+				authorization.Store(r.Header.Get("Authorization"))
+				fmt.Fprintf(w, "[%s]", githubRelease("1.0.0", false))
+			})
+
+			if _, err := updateRepository(httptest.NewRequest("GET", "/update", nil), "acme", repositories[0].Repositories[0]); err != nil {
+				t.Fatalf("updating: %v", err)
+			}
+			if got, _ := authorization.Load().(string); got != test.want {
+				t.Errorf("got Authorization %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTokenRotation(t *testing.T) {
+	t.Setenv("WRIGI_OAUTH_TOKEN", "")
+	useConfig(t, `{"adminToken": "s3cret", "oauthTokens": ["a", "b", "c"], "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	rateLimitLock.Lock()
+	nextTokenIdx = 0
+	rateLimitLock.Unlock()
+
+	var lock sync.Mutex
+	var tokens []string
+	var requests int32
+	releases := releasePages(&requests, []string{githubRelease("1.0.0", false)})
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "token ")
+		lock.Lock()
+		tokens = append(tokens, token)
+		lock.Unlock()
+
+		// b runs out on its first request.
+		remaining := 4000
+		if token == "b" {
+			remaining = 0
+		}
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		switch r.URL.Path {
+		case "/search/issues":
+			fmt.Fprint(w, `{"items": []}`)
+		case "/repos/acme/plugin/issues":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"html_url": "https://github.com/acme/plugin/issues/1", "number": 1}`)
+		default:
+			releases(w, r)
+		}
+	})
+
+	for i := 0; i < 3; i++ {
+		serve(httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(`{"body": "NullPointerException"}`)))
+	}
+	r := httptest.NewRequest("GET", "/cron/update", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	serve(r)
+
+	if len(tokens) < 8 || fmt.Sprint(tokens[:3]) != "[a b c]" {
+		t.Fatalf("got tokens %v, want a round robin starting with a, b, c", tokens)
+	}
+	for idx, token := range tokens[3:] {
+		if token == "b" {
+			t.Errorf("request %d used the rate limited token b: %v", idx+4, tokens)
+		}
+		if token == tokens[idx+2] {
+			t.Errorf("request %d reused %s: %v", idx+4, token, tokens)
+		}
+	}
+}
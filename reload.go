@@ -0,0 +1,89 @@
+package wrigi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// configReloaded is when reloadConfig last swapped in a configuration. It is
+// guarded by repositoriesLock.
+var configReloaded time.Time
+
+// reloadConfig swaps in the configuration from configFile while keeping
+// the fetched state of repositories that are still configured. An invalid
+// file leaves the current configuration in place.
+func reloadConfig() error {
+	file, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	repositoriesLock.Lock()
+	defer repositoriesLock.Unlock()
+
+	previous := map[string]Repository{}
+	for _, owner := range repositories {
+		for _, repository := range owner.Repositories {
+			previous[repositoryKey(owner.Name, repository.Name)] = repository
+		}
+	}
+
+	if err := applyConfig(file); err != nil {
+		return err
+	}
+
+	for oidx, owner := range repositories {
+		for ridx := range owner.Repositories {
+			repository := &repositories[oidx].Repositories[ridx]
+			old, ok := previous[repositoryKey(owner.Name, repository.Name)]
+			if !ok {
+				continue
+			}
+			repository.setFetched(old)
+		}
+	}
+	configReloaded = time.Now()
+	return nil
+}
+
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+
+	// An update works on a copy of the repositories and writes them back
+	// once fetched, so the config is not swapped underneath it.
+	lastUpdateLock.Lock()
+	if updateInProgress {
+		lastUpdateLock.Unlock()
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "An update is in progress. Please come back later.", http.StatusServiceUnavailable)
+		return
+	}
+	updateInProgress = true
+	lastUpdateLock.Unlock()
+
+	err := reloadConfig()
+	finishUpdate(r)
+	if err != nil {
+		c.Errorf("reloading config: %v", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	repositoriesLock.RLock()
+	count := 0
+	for _, owner := range repositories {
+		count += len(owner.Repositories)
+	}
+	repositoriesLock.RUnlock()
+	c.Infof("config reloaded, serving %d repositories", count)
+
+	response, err := json.Marshal(map[string]int{"repositories": count})
+	if err != nil {
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
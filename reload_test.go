@@ -0,0 +1,171 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// useConfigFile points configFile at a file holding config.
+func useConfigFile(t *testing.T, config string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file := configFile
+	configFile = path
+	t.Cleanup(func() { configFile = file })
+}
+
+// noConfigFile points configFile at a file that doesn't exist.
+func noConfigFile(t *testing.T) {
+	t.Helper()
+	file := configFile
+	configFile = filepath.Join(t.TempDir(), "missing.json")
+	t.Cleanup(func() { configFile = file })
+}
+
+func TestReloadHandler(t *testing.T) {
+	const current = `{"adminToken": "s3cret", "oauth": "token", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`
+
+	tests := []struct {
+		name   string
+		config string
+		status int
+		owner  string
+		token  string
+	}{
+		{
+			name:   "valid",
+			config: `{"adminToken": "s3cret", "oauth": "other", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}, {"id": "com.acme.tool", "name": "tool"}]}]}`,
+			status: http.StatusOK,
+			owner:  "acme",
+			token:  "other",
+		},
+		{
+			name:   "unparsable interval",
+			config: `{"adminToken": "s3cret", "update_interval": "5 minutes", "organizations": [{"name": "other", "repositories": [{"id": "com.other", "name": "other"}]}]}`,
+			status: http.StatusInternalServerError,
+			owner:  "acme",
+			token:  "token",
+		},
+		{
+			name:   "invalid pattern",
+			config: `{"adminToken": "s3cret", "organizations": [{"name": "other", "repositories": [{"id": "com.other", "name": "other", "assetPattern": "("}]}]}`,
+			status: http.StatusInternalServerError,
+			owner:  "acme",
+			token:  "token",
+		},
+		{
+			name:   "not JSON",
+			config: `{"organizations": `,
+			status: http.StatusInternalServerError,
+			owner:  "acme",
+			token:  "token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, current)
+			repositories[0].Repositories[0].Versions = RepositoryVersions{"release": {Name: "1.0.0"}}
+			useConfigFile(t, test.config)
+
+			r := httptest.NewRequest("POST", "/admin/reload", nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			if w := serve(r); w.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+
+			if OAuthToken != test.token {
+				t.Errorf("got token %q, want %q", OAuthToken, test.token)
+			}
+			if len(repositories) != 1 || repositories[0].Name != test.owner {
+				t.Fatalf("got organizations %+v, want %s", repositories, test.owner)
+			}
+			if repository, _ := findRepository("acme", "plugin"); repository.Versions["release"].Name != "1.0.0" {
+				t.Errorf("got release %q after the reload, want the fetched 1.0.0", repository.Versions["release"].Name)
+			}
+		})
+	}
+}
+
+func TestInitConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		panics bool
+	}{
+		{name: "valid", config: pluginConfig},
+		{name: "unparsable interval", config: `{"update_interval": "5 minutes"}`, panics: true},
+		{name: "invalid pattern", config: `{"organizations": [{"name": "acme", "repositories": [{"name": "plugin", "channels": [{"name": "nightly", "pattern": "["}]}]}]}`, panics: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, "{}")
+			useConfigFile(t, test.config)
+
+			defer func() {
+				if failure := recover(); (failure != nil) != test.panics {
+					t.Errorf("got panic %v, want a panic %v", failure, test.panics)
+				}
+			}()
+			initConfig()
+		})
+	}
+}
+
+func TestInitConfigMissingFile(t *testing.T) {
+	useConfig(t, pluginConfig)
+	t.Setenv("WRIGI_OAUTH_TOKEN", "")
+	noConfigFile(t)
+
+	initConfig()
+
+	repository, ok := findRepository("go-lang-plugin-org", "go-lang-idea-plugin")
+	if !ok || repository.Id != "ro.redeul.google.go" || len(repositories) != 1 || len(repositories[0].Repositories) != 1 {
+		t.Errorf("got %+v, want only the default Go plugin", repositories)
+	}
+	if OAuthToken != "" || len(OAuthTokens) != 0 {
+		t.Errorf("got the token %q, want none", OAuthToken)
+	}
+}
+
+func TestOAuthTokenSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    string
+		want   string
+	}{
+		{name: "file only", config: `{"oauth": "from-file"}`, want: "from-file"},
+		{name: "environment only", config: `{}`, env: "from-env", want: "from-env"},
+		{name: "both", config: `{"oauth": "from-file"}`, env: "from-env", want: "from-file"},
+		{name: "empty in the file", config: `{"oauth": ""}`, env: "from-env", want: "from-env"},
+		{name: "no file", env: "from-env", want: "from-env"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, "{}")
+			t.Setenv("WRIGI_OAUTH_TOKEN", test.env)
+			if test.config != "" {
+				useConfigFile(t, test.config)
+			} else {
+				noConfigFile(t)
+			}
+
+			initConfig()
+			if OAuthToken != test.want {
+				t.Errorf("got the token %q, want %q", OAuthToken, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package wrigi
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+type errorReport struct {
+	Title         string   `json:"title"`
+	Body          string   `json:"body"`
+	Labels        []string `json:"labels"`
+	PluginVersion string   `json:"pluginVersion"`
+	Fingerprint   string   `json:"fingerprint"`
+}
+
+type pendingReport struct {
+	Owner      string
+	Repository string
+	Body       []byte `datastore:",noindex"`
+	Created    time.Time
+	Attempts   int
+}
+
+var (
+	submitErrorAttempts = 3
+	submitErrorBackoff  = 500 * time.Millisecond
+	submitErrorDeadline = 10 * time.Second
+	QueueFailedReports  bool
+	SubmitErrorLimit    = 10
+	SubmitErrorWindow   = time.Hour
+	SubmitErrorMaxBody  = int64(64 << 10)
+
+	maxReportTitle    = 256
+	maxReportLabels   = 10
+	reportTitle       = "Error report: %s"
+	reportLabel       = "crash-report"
+	errMissingBody    = errors.New("the report has no body")
+	errReportTooLarge = errors.New("the report is too large")
+	errTitleTooLong   = errors.New("the report title is too long")
+	errInvalidLabels  = errors.New("the report labels are invalid")
+)
+
+func issuesURL(owner, repository string) string {
+	return fmt.Sprintf("https://api.github.com/repos/%s/%s/issues", owner, repository)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsLoopback() {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	return host
+}
+
+func submitRateLimited(c appContext, ip string) bool {
+	if SubmitErrorLimit <= 0 {
+		return false
+	}
+	key := "submitError/" + ip
+	if err := cache.Add(c, key, []byte("0"), SubmitErrorWindow); err != nil && err != errNotStored {
+		c.Warningf("submit rate limit for %s: %v", ip, err)
+		return false
+	}
+	count, err := cache.IncrementExisting(c, key, 1)
+	if err != nil {
+		c.Warningf("submit rate limit for %s: %v", ip, err)
+		return false
+	}
+	return count > uint64(SubmitErrorLimit)
+}
+
+func parseReport(data []byte) (errorReport, error) {
+	var report errorReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, err
+	}
+	if strings.TrimSpace(report.Body) == "" {
+		return report, errMissingBody
+	}
+	if len(report.Title) > maxReportTitle {
+		return report, errTitleTooLong
+	}
+	if len(report.Labels) > maxReportLabels {
+		return report, errInvalidLabels
+	}
+	for _, label := range report.Labels {
+		if strings.TrimSpace(label) == "" {
+			return report, errInvalidLabels
+		}
+	}
+
+	if report.Title == "" {
+		summary := strings.TrimSpace(strings.SplitN(strings.TrimSpace(report.Body), "\n", 2)[0])
+		if len(summary) > 80 {
+			summary = summary[:80] + "..."
+		}
+		report.Title = fmt.Sprintf(reportTitle, summary)
+	}
+
+	labeled := false
+	for _, label := range report.Labels {
+		labeled = labeled || label == reportLabel
+	}
+	if !labeled {
+		report.Labels = append(report.Labels, reportLabel)
+	}
+
+	if report.Fingerprint == "" {
+		report.Fingerprint = fmt.Sprintf("%x", sha1.Sum([]byte(report.Body)))
+	}
+	return report, nil
+}
+
+func (report errorReport) issue() ([]byte, error) {
+	return json.Marshal(struct {
+		Title  string   `json:"title"`
+		Body   string   `json:"body"`
+		Labels []string `json:"labels"`
+	}{report.Title, report.Body + "\n\nSignature: " + report.Fingerprint, report.Labels})
+}
+
+func findDuplicate(client *http.Client, owner, repository, signature string) (int, bool) {
+	query := fmt.Sprintf("%q repo:%s/%s is:issue is:open", "Signature: "+signature, owner, repository)
+	url := fmt.Sprintf("https://api.github.com/search/issues?q=%s", neturl.QueryEscape(query))
+
+	request, err := githubRequest(client, "GET", url, nil)
+	if err != nil {
+		return 0, false
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, false
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, false
+	}
+
+	var result struct {
+		Items []struct {
+			Number int `json:"number"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil || len(result.Items) == 0 {
+		return 0, false
+	}
+	return result.Items[0].Number, true
+}
+
+func commentIssue(client *http.Client, owner, repository string, number int, body []byte) (*http.Response, error) {
+	var payload struct {
+		Body string `json:"body"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		payload.Body = string(body)
+	}
+	comment, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/%d/comments", owner, repository, number)
+	request, err := githubRequest(client, "POST", url, comment)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(request)
+}
+
+func githubRequest(client *http.Client, method, url string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", UserAgent)
+	authorize(client, request)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	return request, nil
+}
+
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+func postIssue(client *http.Client, url string, body []byte) (*http.Response, error) {
+	deadline := time.Now().Add(submitErrorDeadline)
+	backoff := submitErrorBackoff
+
+	for attempt := 1; ; attempt++ {
+		request, err := githubRequest(client, "POST", url, body)
+		if err != nil {
+			return nil, err
+		}
+		response, err := client.Do(request)
+		if err == nil {
+			recordRateLimit(request, response.Header)
+		}
+		if err == nil && !retryableStatus(response.StatusCode) {
+			return response, nil
+		}
+		if attempt >= submitErrorAttempts || time.Now().Add(backoff).After(deadline) {
+			return response, err
+		}
+		if err == nil {
+			response.Body.Close()
+		}
+		if err := sleep(client, backoff); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+func queueReport(c appContext, owner, repository string, body []byte) error {
+	report := pendingReport{
+		Owner:      owner,
+		Repository: repository,
+		Body:       body,
+		Created:    time.Now(),
+	}
+	_, err := store.Put(c, newIncompleteKey("PendingReport", nil), &report)
+	return err
+}
+
+func retryErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	c := newContext(r)
+	client := httpClientFactory(r)
+
+	var reports []pendingReport
+	keys, err := store.GetAll(c, entityQuery{Kind: "PendingReport", Order: "Created", Limit: 50}, &reports)
+	if err != nil {
+		c.Errorf("pending reports: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	submitted := 0
+	for idx, report := range reports {
+		response, err := postIssue(client, issuesURL(report.Owner, report.Repository), report.Body)
+		if err == nil {
+			response.Body.Close()
+		}
+
+		if err != nil || retryableStatus(response.StatusCode) {
+			report.Attempts++
+			if _, err := store.Put(c, keys[idx], &report); err != nil {
+				c.Errorf("pending report %s/%s: %v", report.Owner, report.Repository, err)
+			}
+			continue
+		}
+
+		if response.StatusCode != http.StatusCreated {
+			c.Warningf("pending report %s/%s rejected with status %d", report.Owner, report.Repository, response.StatusCode)
+		}
+		if err := store.Delete(c, keys[idx]); err != nil {
+			c.Errorf("pending report %s/%s: %v", report.Owner, report.Repository, err)
+			continue
+		}
+		submitted++
+	}
+
+	w.Write([]byte(fmt.Sprintf("Submitted %d of %d pending reports", submitted, len(reports))))
+}
@@ -0,0 +1,477 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const errorTargetsConfig = `{"organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin",
+	"errorTargets": [{"maxVersion": "2.0", "owner": "acme", "repository": "legacy"}]}]}]}`
+
+// stubIssues answers the duplicate search with no match and records where
+// issues are opened.
+func stubIssues(t *testing.T, opened *[]string) {
+	t.Helper()
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/search/issues" {
+			fmt.Fprint(w, `{"items": []}`)
+			return
+		}
+		*opened = append(*opened, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"html_url": "https://github.com/acme/plugin/issues/1", "number": 1}`)
+	})
+}
+
+func TestSubmitErrorTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    string
+	}{
+		{name: "old version", version: "1.9.3", want: "/repos/acme/legacy/issues"},
+		{name: "new version", version: "2.0.0", want: "/repos/acme/plugin/issues"},
+		{name: "no version", want: "/repos/acme/plugin/issues"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, errorTargetsConfig)
+			var opened []string
+			stubIssues(t, &opened)
+
+			body := fmt.Sprintf(`{"body": "NullPointerException", "pluginVersion": %q}`, test.version)
+			w := serve(httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(body)))
+			if w.Code != http.StatusCreated {
+				t.Fatalf("got status %d, want 201: %s", w.Code, w.Body)
+			}
+			if len(opened) != 1 || opened[0] != test.want {
+				t.Errorf("opened %v, want %s", opened, test.want)
+			}
+		})
+	}
+}
+
+func TestErrorTargetsHidden(t *testing.T) {
+	useConfig(t, errorTargetsConfig)
+
+	for _, url := range []string{"/", "/?raw=true"} {
+		w := serve(httptest.NewRequest("GET", url, nil))
+		if strings.Contains(w.Body.String(), "legacy") || strings.Contains(w.Body.String(), "ErrorTargets") {
+			t.Errorf("%s exposes the error targets: %s", url, w.Body)
+		}
+	}
+	if repository, _ := findRepository("acme", "plugin"); len(repository.ErrorTargets) != 1 {
+		t.Errorf("got error targets %v, want the configured one", repository.ErrorTargets)
+	}
+}
+
+func TestSubmitErrorRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		queue    bool
+		status   int
+		posts    int
+		pending  int
+	}{
+		{name: "first attempt", status: http.StatusCreated, posts: 1},
+		{name: "retried after a 503", failures: 1, status: http.StatusCreated, posts: 2},
+		{name: "gives up", failures: submitErrorAttempts, status: http.StatusBadGateway, posts: submitErrorAttempts},
+		{name: "queued", failures: submitErrorAttempts, queue: true, status: http.StatusAccepted, posts: submitErrorAttempts, pending: 1},
+	}
+
+	backoff := submitErrorBackoff
+	submitErrorBackoff = time.Millisecond
+	t.Cleanup(func() { submitErrorBackoff = backoff })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, fmt.Sprintf(`{"queueFailedReports": %v, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`, test.queue))
+			var posts int32
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/search/issues" {
+					fmt.Fprint(w, `{"items": []}`)
+					return
+				}
+				if int(atomic.AddInt32(&posts, 1)) <= test.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					fmt.Fprint(w, `{"message": "Service Unavailable"}`)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"html_url": "https://github.com/acme/plugin/issues/1", "number": 1}`)
+			})
+
+			w := serve(httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(`{"body": "NullPointerException"}`)))
+			if w.Code != test.status {
+				t.Errorf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if posts := int(atomic.LoadInt32(&posts)); posts != test.posts {
+				t.Errorf("got %d posts, want %d", posts, test.posts)
+			}
+
+			c := newContext(httptest.NewRequest("GET", "/", nil))
+			var pending []pendingReport
+			if _, err := store.GetAll(c, entityQuery{Kind: "PendingReport"}, &pending); err != nil || len(pending) != test.pending {
+				t.Errorf("got %d pending reports, want %d: %v", len(pending), test.pending, err)
+			}
+		})
+	}
+}
+
+func TestSubmitErrorAllowedRepositories(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository string
+		status     int
+		opened     string
+	}{
+		{name: "configured", repository: "acme/plugin", status: http.StatusCreated, opened: "/repos/acme/plugin/issues"},
+		{name: "differently cased", repository: "Acme/Plugin", status: http.StatusCreated, opened: "/repos/acme/plugin/issues"},
+		{name: "not configured", repository: "acme/private", status: http.StatusNotFound},
+		{name: "other owner", repository: "evil/plugin", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			var opened []string
+			stubIssues(t, &opened)
+
+			w := serve(httptest.NewRequest("POST", "/"+test.repository+"/submitError", strings.NewReader(`{"body": "NullPointerException"}`)))
+			if w.Code != test.status {
+				t.Errorf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if strings.Join(opened, ",") != test.opened {
+				t.Errorf("opened %v, want %q", opened, test.opened)
+			}
+		})
+	}
+}
+
+func TestSubmitErrorResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		want     int
+		body     string
+	}{
+		{
+			name:     "created",
+			status:   http.StatusCreated,
+			response: `{"html_url": "https://github.com/acme/plugin/issues/7", "number": 7}`,
+			want:     http.StatusCreated,
+			body:     `{"url":"https://github.com/acme/plugin/issues/7","number":7}`,
+		},
+		{
+			name:     "rejected",
+			status:   http.StatusUnprocessableEntity,
+			response: `{"message": "Validation Failed"}`,
+			want:     http.StatusUnprocessableEntity,
+			body:     `{"error":"Validation Failed"}`,
+		},
+		{
+			name:   "rejected without a message",
+			status: http.StatusGone,
+			want:   http.StatusGone,
+			body:   `{"error":"410 Gone"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/search/issues" {
+					fmt.Fprint(w, `{"items": []}`)
+					return
+				}
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.response)
+			})
+
+			w := serve(httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(`{"body": "NullPointerException"}`)))
+			if w.Code != test.want || strings.TrimSpace(w.Body.String()) != test.body {
+				t.Errorf("got status %d and %s, want %d and %s", w.Code, w.Body, test.want, test.body)
+			}
+		})
+	}
+}
+
+func TestSubmitErrorRateLimit(t *testing.T) {
+	useConfig(t, `{"submitErrorLimit": 2, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	var opened []string
+	stubIssues(t, &opened)
+
+	submit := func(remoteAddr, forwardedFor string) int {
+		r := httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(`{"body": "NullPointerException"}`))
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return serve(r).Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := submit("192.0.2.1:1234", ""); code != http.StatusCreated {
+			t.Fatalf("request %d: got status %d, want 201", i+1, code)
+		}
+	}
+	if code := submit("192.0.2.1:1234", ""); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d after the limit, want 429", code)
+	}
+	if code := submit("192.0.2.2:1234", ""); code != http.StatusCreated {
+		t.Errorf("got status %d for another client, want 201", code)
+	}
+	if len(opened) != 3 {
+		t.Errorf("opened %d issues, want 3", len(opened))
+	}
+
+	for i := 0; i < 2; i++ {
+		submit("127.0.0.1:1234", "198.51.100.7, 10.0.0.1")
+	}
+	if code := submit("127.0.0.1:1234", "198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d for a forwarded client after the limit, want 429", code)
+	}
+}
+
+func TestSubmitErrorDuplicates(t *testing.T) {
+	tests := []struct {
+		name      string
+		search    string
+		report    string
+		signature string
+		want      string
+		status    int
+		body      string
+	}{
+		{
+			name:      "duplicate",
+			search:    `{"items": [{"number": 12}]}`,
+			report:    `{"body": "NullPointerException"}`,
+			signature: fmt.Sprintf("%x", sha1.Sum([]byte("NullPointerException"))),
+			want:      "/repos/acme/plugin/issues/12/comments",
+			status:    http.StatusOK,
+			body:      `{"url":"https://github.com/acme/plugin/issues/12","number":12,"duplicate":true}`,
+		},
+		{
+			name:      "fingerprint",
+			search:    `{"items": [{"number": 12}]}`,
+			report:    `{"body": "NullPointerException", "fingerprint": "npe-in-parser"}`,
+			signature: "npe-in-parser",
+			want:      "/repos/acme/plugin/issues/12/comments",
+			status:    http.StatusOK,
+			body:      `{"url":"https://github.com/acme/plugin/issues/12","number":12,"duplicate":true}`,
+		},
+		{
+			name:      "new issue",
+			search:    `{"items": []}`,
+			report:    `{"body": "NullPointerException"}`,
+			signature: fmt.Sprintf("%x", sha1.Sum([]byte("NullPointerException"))),
+			want:      "/repos/acme/plugin/issues",
+			status:    http.StatusCreated,
+			body:      `{"url":"https://github.com/acme/plugin/issues/1","number":1}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			var query string
+			var posted []string
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/search/issues" {
+					query = r.URL.Query().Get("q")
+					fmt.Fprint(w, test.search)
+					return
+				}
+				posted = append(posted, r.URL.Path)
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"html_url": "https://github.com/acme/plugin/issues/1", "number": 1}`)
+			})
+
+			w := serve(httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(test.report)))
+			if w.Code != test.status || strings.TrimSpace(w.Body.String()) != test.body {
+				t.Errorf("got status %d and %s, want %d and %s", w.Code, w.Body, test.status, test.body)
+			}
+			if !strings.Contains(query, "Signature: "+test.signature) || !strings.Contains(query, "repo:acme/plugin") {
+				t.Errorf("searched for %q, want the signature %s", query, test.signature)
+			}
+			if len(posted) != 1 || posted[0] != test.want {
+				t.Errorf("posted to %v, want %s", posted, test.want)
+			}
+		})
+	}
+}
+
+func TestSubmitErrorPayload(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+		title  string
+		labels []string
+		err    string
+	}{
+		{
+			name:   "defaults",
+			report: `{"body": "NullPointerException\n\tat Parser.parse"}`,
+			title:  "Error report: NullPointerException",
+			labels: []string{"crash-report"},
+		},
+		{
+			name:   "title and labels",
+			report: `{"title": "Parser crash", "body": "NullPointerException", "labels": ["parser"]}`,
+			title:  "Parser crash",
+			labels: []string{"parser", "crash-report"},
+		},
+		{
+			name:   "default label given",
+			report: `{"body": "NullPointerException", "labels": ["crash-report"]}`,
+			title:  "Error report: NullPointerException",
+			labels: []string{"crash-report"},
+		},
+		{name: "missing body", report: `{"title": "Parser crash"}`, err: errMissingBody.Error()},
+		{name: "blank body", report: `{"body": "  \n"}`, err: errMissingBody.Error()},
+		{name: "long title", report: fmt.Sprintf(`{"title": %q, "body": "NPE"}`, strings.Repeat("x", 257)), err: errTitleTooLong.Error()},
+		{name: "blank label", report: `{"body": "NPE", "labels": [" "]}`, err: errInvalidLabels.Error()},
+		{name: "not JSON", report: `NullPointerException`, err: "invalid character"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			var issues []map[string]interface{}
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/search/issues" {
+					fmt.Fprint(w, `{"items": []}`)
+					return
+				}
+				var issue map[string]interface{}
+				if err := json.NewDecoder(r.Body).Decode(&issue); err != nil {
+					t.Errorf("decoding the issue: %v", err)
+				}
+				issues = append(issues, issue)
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"html_url": "https://github.com/acme/plugin/issues/1", "number": 1}`)
+			})
+
+			w := serve(httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(test.report)))
+			if test.err != "" {
+				if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), test.err) {
+					t.Errorf("got status %d and %s, want 400 and %q", w.Code, w.Body, test.err)
+				}
+				if len(issues) != 0 {
+					t.Errorf("opened %v for a rejected report", issues)
+				}
+				return
+			}
+
+			if w.Code != http.StatusCreated || len(issues) != 1 {
+				t.Fatalf("got status %d and %d issues, want 201 and one issue", w.Code, len(issues))
+			}
+			if issues[0]["title"] != test.title {
+				t.Errorf("got title %q, want %q", issues[0]["title"], test.title)
+			}
+			if labels := fmt.Sprint(issues[0]["labels"]); labels != fmt.Sprint(test.labels) {
+				t.Errorf("got labels %s, want %v", labels, test.labels)
+			}
+			if body, _ := issues[0]["body"].(string); !strings.HasPrefix(body, "NullPointerException") || !strings.Contains(body, "Signature: ") {
+				t.Errorf("got body %q, want the report and its signature", body)
+			}
+		})
+	}
+}
+
+func TestSubmitErrorAuthorization(t *testing.T) {
+	tests := []struct {
+		name     string
+		search   string
+		requests []string
+	}{
+		{name: "new issue", search: `{"items": []}`, requests: []string{"GET /search/issues", "POST /repos/acme/plugin/issues"}},
+		{name: "duplicate", search: `{"items": [{"number": 12}]}`, requests: []string{"GET /search/issues", "POST /repos/acme/plugin/issues/12/comments"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("WRIGI_OAUTH_TOKEN", "")
+			useConfig(t, `{"oauth": "t0ken", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			var requests []string
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				request := r.Method + " " + r.URL.Path
+				requests = append(requests, request)
+				if got := r.Header.Get("Authorization"); got != "token t0ken" {
+					t.Errorf("%s: got Authorization %q, want token t0ken", request, got)
+				}
+				if r.URL.Query().Get("access_token") != "" {
+					t.Errorf("%s: the token is in the query %s", request, r.URL.RawQuery)
+				}
+				if r.URL.Path == "/search/issues" {
+					fmt.Fprint(w, test.search)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"html_url": "https://github.com/acme/plugin/issues/1", "number": 1}`)
+			})
+
+			r := httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(`{"body": "NullPointerException"}`))
+			serve(r)
+			if fmt.Sprint(requests) != fmt.Sprint(test.requests) {
+				t.Errorf("got requests %v, want %v", requests, test.requests)
+			}
+			if got := r.Header.Get("Authorization"); got != "" {
+				t.Errorf("the inbound request got Authorization %q", got)
+			}
+		})
+	}
+}
+
+func TestSubmitErrorValidation(t *testing.T) {
+	report := func(size int) string {
+		return fmt.Sprintf(`{"body": %q}`, strings.Repeat("x", size-len(`{"body": ""}`)))
+	}
+	tests := []struct {
+		name   string
+		report string
+		status int
+		err    string
+		opened int
+	}{
+		{name: "valid", report: `{"body": "NullPointerException"}`, status: http.StatusCreated, opened: 1},
+		{name: "at the limit", report: report(256), status: http.StatusCreated, opened: 1},
+		{name: "invalid JSON", report: `{"body": `, status: http.StatusBadRequest, err: "unexpected end of JSON input"},
+		{name: "oversized", report: report(257), status: http.StatusBadRequest, err: errReportTooLarge.Error()},
+		{name: "oversized invalid JSON", report: strings.Repeat("{", 1024), status: http.StatusBadRequest, err: errReportTooLarge.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"submitErrorMaxBody": 256, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			var opened []string
+			stubIssues(t, &opened)
+
+			w := serve(httptest.NewRequest("POST", "/acme/plugin/submitError", strings.NewReader(test.report)))
+			if w.Code != test.status {
+				t.Errorf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), test.err) {
+				t.Errorf("got %s, want %q", w.Body, test.err)
+			}
+			if len(opened) != test.opened {
+				t.Errorf("opened %v, want %d issues", opened, test.opened)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package wrigi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+type requestIDContext struct {
+	appContext
+	id string
+}
+
+func (c requestIDContext) Debugf(format string, args ...interface{}) {
+	c.appContext.Debugf("[%s] "+format, append([]interface{}{c.id}, args...)...)
+}
+
+func (c requestIDContext) Infof(format string, args ...interface{}) {
+	c.appContext.Infof("[%s] "+format, append([]interface{}{c.id}, args...)...)
+}
+
+func (c requestIDContext) Warningf(format string, args ...interface{}) {
+	c.appContext.Warningf("[%s] "+format, append([]interface{}{c.id}, args...)...)
+}
+
+func (c requestIDContext) Errorf(format string, args ...interface{}) {
+	c.appContext.Errorf("[%s] "+format, append([]interface{}{c.id}, args...)...)
+}
+
+func (c requestIDContext) Criticalf(format string, args ...interface{}) {
+	c.appContext.Criticalf("[%s] "+format, append([]interface{}{c.id}, args...)...)
+}
+
+func (c requestIDContext) unwrap() appContext {
+	return c.appContext
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, char := range id {
+		if char <= ' ' || char > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID requestIDHandler stored in the request's own
+// X-Request-ID header. The header is used rather than r.WithContext
+// because appengine.NewContext needs the original *http.Request.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); validRequestID(id) {
+		return id
+	}
+	return ""
+}
+
+// newContext returns the platform context of r with its log lines
+// prefixed by the request ID.
+func newContext(r *http.Request) appContext {
+	c := platformContext(r)
+	if id := requestID(r); id != "" {
+		return requestIDContext{appContext: c, id: id}
+	}
+	return c
+}
+
+func requestIDHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			random := make([]byte, 16)
+			if _, err := rand.Read(random); err != nil {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			id = hex.EncodeToString(random)
+		}
+
+		r.Header.Set("X-Request-ID", id)
+		w.Header().Set("X-Request-ID", id)
+		handler.ServeHTTP(w, r)
+	})
+}
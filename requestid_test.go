@@ -0,0 +1,72 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingLogger keeps the log lines instead of printing them.
+type recordingLogger struct {
+	lines *[]string
+}
+
+func (l recordingLogger) record(format string, args ...interface{}) {
+	*l.lines = append(*l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l recordingLogger) Debugf(format string, args ...interface{})    { l.record(format, args...) }
+func (l recordingLogger) Infof(format string, args ...interface{})     { l.record(format, args...) }
+func (l recordingLogger) Warningf(format string, args ...interface{})  { l.record(format, args...) }
+func (l recordingLogger) Errorf(format string, args ...interface{})    { l.record(format, args...) }
+func (l recordingLogger) Criticalf(format string, args ...interface{}) { l.record(format, args...) }
+
+func TestRequestIDHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		kept bool
+	}{
+		{name: "generated"},
+		{name: "client supplied", sent: "client-id-42", kept: true},
+		{name: "with spaces", sent: "not a valid id"},
+		{name: "too long", sent: strings.Repeat("x", 129)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var logged []string
+			var seen string
+			handler := requestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestID(r)
+				requestIDContext{appContext: recordingLogger{&logged}, id: seen}.Infof("handled %s", r.URL.Path)
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if test.sent != "" {
+				r.Header.Set("X-Request-ID", test.sent)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			id := w.Header().Get("X-Request-ID")
+			if !validRequestID(id) {
+				t.Fatalf("got the invalid ID %q", id)
+			}
+			if (id == test.sent) != test.kept {
+				t.Errorf("got ID %q for %q, want it kept %v", id, test.sent, test.kept)
+			}
+			if seen != id {
+				t.Errorf("the handler saw ID %q, the response has %q", seen, id)
+			}
+			if len(logged) != 1 || logged[0] != "["+id+"] handled /" {
+				t.Errorf("got log lines %q, want them prefixed with the ID", logged)
+			}
+		})
+	}
+}
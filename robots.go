@@ -0,0 +1,16 @@
+package wrigi
+
+import (
+	"net/http"
+)
+
+var RobotsAllowRoot bool
+
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("User-agent: *\n"))
+	if RobotsAllowRoot {
+		w.Write([]byte("Allow: /$\n"))
+	}
+	w.Write([]byte("Disallow: /\n"))
+}
@@ -0,0 +1,38 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRobotsHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{name: "default", config: `{}`, want: "User-agent: *\nDisallow: /\n"},
+		{name: "root crawlable", config: `{"robotsAllowRoot": true}`, want: "User-agent: *\nAllow: /$\nDisallow: /\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, test.config)
+
+			w := serve(httptest.NewRequest("GET", "/robots.txt", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+				t.Errorf("got Content-Type %q, want text/plain; charset=utf-8", got)
+			}
+			if w.Body.String() != test.want {
+				t.Errorf("got %q, want %q", w.Body, test.want)
+			}
+		})
+	}
+}
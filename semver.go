@@ -0,0 +1,97 @@
+package wrigi
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type semver struct {
+	major, minor, patch uint64
+	prerelease          []string
+}
+
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+func parseSemver(tag string) (semver, bool) {
+	match := semverPattern.FindStringSubmatch(strings.TrimSpace(tag))
+	if match == nil {
+		return semver{}, false
+	}
+
+	var version semver
+	version.major, _ = strconv.ParseUint(match[1], 10, 64)
+	version.minor, _ = strconv.ParseUint(match[2], 10, 64)
+	version.patch, _ = strconv.ParseUint(match[3], 10, 64)
+	if match[4] != "" {
+		version.prerelease = strings.Split(match[4], ".")
+	}
+	return version, true
+}
+
+func compareNumbers(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func comparePrerelease(a, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+
+	for idx := 0; idx < len(a) && idx < len(b); idx++ {
+		left, leftErr := strconv.ParseUint(a[idx], 10, 64)
+		right, rightErr := strconv.ParseUint(b[idx], 10, 64)
+		switch {
+		case leftErr == nil && rightErr == nil:
+			if result := compareNumbers(left, right); result != 0 {
+				return result
+			}
+		case leftErr == nil:
+			return -1
+		case rightErr == nil:
+			return 1
+		default:
+			if result := strings.Compare(a[idx], b[idx]); result != 0 {
+				return result
+			}
+		}
+	}
+	return compareNumbers(uint64(len(a)), uint64(len(b)))
+}
+
+func (v semver) compare(other semver) int {
+	if result := compareNumbers(v.major, other.major); result != 0 {
+		return result
+	}
+	if result := compareNumbers(v.minor, other.minor); result != 0 {
+		return result
+	}
+	if result := compareNumbers(v.patch, other.patch); result != 0 {
+		return result
+	}
+	return comparePrerelease(v.prerelease, other.prerelease)
+}
+
+func newerVersion(candidate, current Version) bool {
+	if current.Name == "" {
+		return true
+	}
+
+	candidateSemver, candidateOk := parseSemver(candidate.Tag)
+	currentSemver, currentOk := parseSemver(current.Tag)
+	if candidateOk && currentOk {
+		return candidateSemver.compare(currentSemver) > 0
+	}
+	return candidate.Date > current.Date
+}
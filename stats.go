@@ -0,0 +1,67 @@
+package wrigi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type RepositoryStats struct {
+	Owner          string
+	Repository     string
+	TotalDownloads uint64
+	Channels       map[string]uint32
+	ProxyDownloads map[string]int64 `json:",omitempty"`
+	LastStatus     int              `json:",omitempty"`
+	Circuit        string
+}
+
+// totalDownloads sums the channels' downloads, which can overflow the
+// per-asset uint32 counts.
+func (repository Repository) totalDownloads() uint64 {
+	var total uint64
+	for _, channel := range repository.channels() {
+		total += uint64(repository.Versions[channel.Name].DownloadCount)
+	}
+	return total
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	c := newContext(r)
+	proxied, err := downloadCounts(c)
+	if err != nil {
+		c.Errorf("download counters: %v", err)
+	}
+
+	now := time.Now()
+	stats := []RepositoryStats{}
+	repositoriesLock.RLock()
+	for _, organization := range repositories {
+		for _, repository := range organization.Repositories {
+			entry := RepositoryStats{
+				Owner:          organization.Name,
+				Repository:     repository.Name,
+				TotalDownloads: repository.TotalDownloads,
+				Channels:       map[string]uint32{},
+				ProxyDownloads: proxied[repositoryKey(organization.Name, repository.Name)],
+				LastStatus:     repository.lastStatus,
+				Circuit:        breakerState(hostOf(repository.releasesURL(organization.Name)), now),
+			}
+			for _, channel := range repository.channels() {
+				if version := repository.Versions[channel.Name]; version.Name != "" {
+					entry.Channels[channel.Name] = version.DownloadCount
+				}
+			}
+			stats = append(stats, entry)
+		}
+	}
+	repositoriesLock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	response, err := json.Marshal(wrapJSON(r, stats))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(response)
+}
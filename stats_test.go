@@ -0,0 +1,42 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTotalDownloads(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions RepositoryVersions
+		want     uint64
+	}{
+		{name: "no versions", versions: RepositoryVersions{}},
+		{name: "sum", versions: RepositoryVersions{"release": {DownloadCount: 10}, "beta": {DownloadCount: 5}}, want: 15},
+		{name: "at the uint32 limit", versions: RepositoryVersions{"release": {DownloadCount: math.MaxUint32}}, want: math.MaxUint32},
+		{name: "past the uint32 limit", versions: RepositoryVersions{"release": {DownloadCount: math.MaxUint32}, "beta": {DownloadCount: 10}}, want: math.MaxUint32 + 10},
+		{name: "every channel at the limit", versions: RepositoryVersions{"release": {DownloadCount: math.MaxUint32}, "beta": {DownloadCount: math.MaxUint32}, "alpha": {DownloadCount: math.MaxUint32}}, want: 3 * math.MaxUint32},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, pluginConfig)
+			repositories[0].Repositories[0].Versions = test.versions
+			repositories[0].Repositories[0].TotalDownloads = repositories[0].Repositories[0].totalDownloads()
+
+			w := serve(httptest.NewRequest("GET", "/stats", nil))
+			var stats []RepositoryStats
+			if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || len(stats) != 1 {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if stats[0].TotalDownloads != test.want {
+				t.Errorf("got %d downloads, want %d", stats[0].TotalDownloads, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package wrigi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	repositoryEntity struct {
+		Versions  []byte  `datastore:",noindex"`
+		ETag      string  `datastore:",noindex"`
+		Rating    float64 `datastore:",noindex"`
+		LastFetch time.Time
+	}
+
+	ownedRepository struct {
+		owner      string
+		repository Repository
+	}
+)
+
+var loadRepositoriesOnce sync.Once
+
+func repositoryEntityKey(owner, repository string) *entityKey {
+	return newKey("Repository", repositoryKey(owner, repository), nil)
+}
+
+func saveRepositories(c appContext, updated []ownedRepository) error {
+	if len(updated) == 0 {
+		return nil
+	}
+
+	keys := make([]*entityKey, len(updated))
+	entities := make([]repositoryEntity, len(updated))
+	for idx, entry := range updated {
+		versions, err := json.Marshal(entry.repository.Versions)
+		if err != nil {
+			return err
+		}
+		keys[idx] = repositoryEntityKey(entry.owner, entry.repository.Name)
+		entities[idx] = repositoryEntity{
+			Versions:  versions,
+			ETag:      entry.repository.etag,
+			Rating:    float64(entry.repository.Rating),
+			LastFetch: entry.repository.lastFetch,
+		}
+	}
+
+	_, err := store.PutMulti(c, keys, entities)
+	return err
+}
+
+func loadRepositories(c appContext) error {
+	var (
+		keys    []*entityKey
+		indexes [][2]int
+	)
+
+	repositoriesLock.Lock()
+	defer repositoriesLock.Unlock()
+	for oidx, owner := range repositories {
+		for ridx, repository := range owner.Repositories {
+			keys = append(keys, repositoryEntityKey(owner.Name, repository.Name))
+			indexes = append(indexes, [2]int{oidx, ridx})
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	entities := make([]repositoryEntity, len(keys))
+	err := store.GetMulti(c, keys, entities)
+	errs, multi := err.(multiError)
+	if err != nil && !multi {
+		return err
+	}
+
+	for idx, entity := range entities {
+		if multi && errs[idx] != nil {
+			if errs[idx] != errNoSuchEntity {
+				c.Errorf("loading %s: %v", keys[idx].StringID, errs[idx])
+			}
+			continue
+		}
+
+		repository := &repositories[indexes[idx][0]].Repositories[indexes[idx][1]]
+		if err := json.Unmarshal(entity.Versions, &repository.Versions); err != nil {
+			c.Errorf("loading %s: %v", keys[idx].StringID, err)
+			continue
+		}
+		repository.TotalDownloads = repository.totalDownloads()
+		repository.Rating = float32(entity.Rating)
+		repository.etag = entity.ETag
+		repository.lastFetch = entity.LastFetch
+		repository.lastSuccess = entity.LastFetch
+	}
+	return nil
+}
+
+func lastKnownGood(c appContext, owner string, repository Repository) Repository {
+	for _, version := range repository.Versions {
+		if version.Name != "" {
+			return repository
+		}
+	}
+
+	var entity repositoryEntity
+	if err := store.Get(c, repositoryEntityKey(owner, repository.Name), &entity); err != nil {
+		if err != errNoSuchEntity {
+			c.Errorf("loading %s/%s: %v", owner, repository.Name, err)
+		}
+		return repository
+	}
+
+	var versions RepositoryVersions
+	if err := json.Unmarshal(entity.Versions, &versions); err != nil {
+		c.Errorf("loading %s/%s: %v", owner, repository.Name, err)
+		return repository
+	}
+	repository.Versions = versions
+	repository.TotalDownloads = repository.totalDownloads()
+	repository.Rating = float32(entity.Rating)
+	repository.etag = entity.ETag
+	return repository
+}
+
+func versionsCacheKey(owner, repository string) string {
+	return "versions/" + repositoryKey(owner, repository)
+}
+
+func cachedVersions(c appContext, owner, repository string) (RepositoryVersions, bool) {
+	var versions RepositoryVersions
+	data, err := cache.Get(c, versionsCacheKey(owner, repository))
+	if err == nil {
+		err = json.Unmarshal(data, &versions)
+	}
+	if err != nil {
+		if err != errCacheMiss {
+			c.Warningf("memcache %s/%s: %v", owner, repository, err)
+		}
+		return versions, false
+	}
+	return versions, true
+}
+
+func cacheVersions(c appContext, owner string, repository Repository) {
+	data, err := json.Marshal(repository.Versions)
+	if err == nil {
+		err = cache.Set(c, versionsCacheKey(owner, repository.Name), data, repository.updateInterval())
+	}
+	if err != nil {
+		c.Warningf("memcache %s/%s: %v", owner, repository.Name, err)
+	}
+}
+
+func withRepositories(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loadRepositoriesOnce.Do(func() {
+			c := newContext(r)
+			if err := loadRepositories(c); err != nil {
+				c.Errorf("loading repositories: %v", err)
+			}
+		})
+		handler(w, r)
+	}
+}
@@ -0,0 +1,164 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// restartInstance forgets everything but the datastore, as a new instance
+// would.
+func restartInstance(t *testing.T) {
+	t.Helper()
+	cache = newMemoryCache()
+	loadRepositoriesOnce = sync.Once{}
+	if err := applyConfig([]byte(pluginConfig)); err != nil {
+		t.Fatalf("applying the config: %v", err)
+	}
+}
+
+func TestRepositoriesSurviveRestart(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	var requests int32
+	stubAPI(t, releasePages(&requests, []string{githubRelease("1.0.0", false)}))
+
+	r := httptest.NewRequest("GET", "/update", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("updating: got status %d: %s", w.Code, w.Body)
+	}
+	updated, _ := findRepository("acme", "plugin")
+
+	restartInstance(t)
+	if repository, _ := findRepository("acme", "plugin"); repository.Versions["release"].Name != "" {
+		t.Fatalf("the restarted instance kept %+v in memory", repository.Versions)
+	}
+
+	w := serve(httptest.NewRequest("GET", "/acme/plugin/release.xml", nil))
+	if !strings.Contains(w.Body.String(), "<version>1.0.0</version>") {
+		t.Errorf("got %s, want the stored release", w.Body)
+	}
+	repository, _ := findRepository("acme", "plugin")
+	if repository.Rating != updated.Rating || !repository.lastFetch.Equal(updated.lastFetch) || repository.etag != updated.etag {
+		t.Errorf("got rating %v, last fetch %s and ETag %q, want %v, %s and %q",
+			repository.Rating, repository.lastFetch, repository.etag, updated.Rating, updated.lastFetch, updated.etag)
+	}
+}
+
+func TestLastKnownGood(t *testing.T) {
+	useConfig(t, pluginConfig)
+	storeRepository(t, "acme", Repository{Name: "plugin", Versions: RepositoryVersions{
+		"release": {Name: "0.9.0", Tag: "0.9.0", Url: "https://example.com/plugin-0.9.0.zip"},
+	}})
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone fishing", http.StatusNotFound)
+	})
+
+	repository, err := updateRepository(httptest.NewRequest("GET", "/update", nil), "acme", repositories[0].Repositories[0])
+	if err == nil {
+		t.Fatal("the failed fetch succeeded")
+	}
+	if got := repository.Versions["release"].Name; got != "0.9.0" {
+		t.Errorf("got release %q, want the stored 0.9.0", got)
+	}
+}
+
+func TestVersionsCache(t *testing.T) {
+	cached := `{"release": {"Name": "0.9.0", "Tag": "0.9.0", "Url": "https://example.com/plugin-0.9.0.zip"}}`
+
+	tests := []struct {
+		name     string
+		cached   bool
+		expires  time.Duration
+		url      string
+		requests int32
+		want     string
+	}{
+		{name: "hit", cached: true, url: "/update", want: "0.9.0"},
+		{name: "miss", url: "/update", requests: 1, want: "1.0.0"},
+		{name: "expired", cached: true, expires: time.Millisecond, url: "/update", requests: 1, want: "1.0.0"},
+		{name: "forced", cached: true, url: "/cron/update", requests: 1, want: "1.0.0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"adminToken": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			var requests int32
+			stubAPI(t, releasePages(&requests, []string{githubRelease("1.0.0", false)}))
+			c := newContext(httptest.NewRequest("GET", "/", nil))
+			if test.cached {
+				expires := time.Hour
+				if test.expires != 0 {
+					expires = test.expires
+				}
+				cache.Set(c, versionsCacheKey("acme", "plugin"), []byte(cached), expires)
+				time.Sleep(2 * test.expires)
+			}
+
+			r := httptest.NewRequest("GET", test.url, nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			if w := serve(r); w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body)
+			}
+
+			repository, _ := findRepository("acme", "plugin")
+			if got := repository.Versions["release"].Name; got != test.want {
+				t.Errorf("got release %q, want %q", got, test.want)
+			}
+			if requests := atomic.LoadInt32(&requests); requests != test.requests {
+				t.Errorf("got %d release listings, want %d", requests, test.requests)
+			}
+			if versions, ok := cachedVersions(c, "acme", "plugin"); !ok || versions["release"].Name != test.want {
+				t.Errorf("got cached versions %v, want %s", versions, test.want)
+			}
+		})
+	}
+}
+
+func TestFailedFetchKeepsGoodData(t *testing.T) {
+	useConfig(t, `{"adminToken": "s3cret", "fetchAttempts": 1, "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+	var requests, failing, failures int32
+	good := releasePages(&requests, []string{githubRelease("1.0.0", false)})
+	stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			atomic.AddInt32(&failures, 1)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		good(w, r)
+	})
+
+	update := func() {
+		r := httptest.NewRequest("GET", "/cron/update", nil)
+		r.Header.Set("Authorization", "Bearer s3cret")
+		serve(r)
+	}
+	served := func() string {
+		return serve(httptest.NewRequest("GET", "/acme/plugin/release.xml", nil)).Body.String()
+	}
+
+	update()
+	if !strings.Contains(served(), "<version>1.0.0</version>") {
+		t.Fatalf("the first fetch didn't give the release")
+	}
+	atomic.StoreInt32(&failing, 1)
+	update()
+	if atomic.LoadInt32(&failures) == 0 {
+		t.Fatal("the second update didn't reach GitHub")
+	}
+
+	if body := served(); !strings.Contains(body, "<version>1.0.0</version>") {
+		t.Errorf("got %s after the failed fetch, want the good release", body)
+	}
+	restartInstance(t)
+	if body := served(); !strings.Contains(body, "<version>1.0.0</version>") {
+		t.Errorf("got %s on a cold instance, want the persisted release", body)
+	}
+}
@@ -0,0 +1,137 @@
+package wrigi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	timeoutResponseWriter struct {
+		lock     sync.Mutex
+		header   http.Header
+		status   int
+		buffer   bytes.Buffer
+		timedOut bool
+	}
+
+	contextTransport struct {
+		ctx  context.Context
+		base http.RoundTripper
+	}
+)
+
+var (
+	RequestTimeout      = 50 * time.Second
+	requestContexts     = map[*http.Request]context.Context{}
+	requestContextsLock sync.Mutex
+)
+
+func (w *timeoutResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutResponseWriter) WriteHeader(status int) {
+	w.lock.Lock()
+	w.status = status
+	w.lock.Unlock()
+}
+
+func (w *timeoutResponseWriter) Write(data []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.buffer.Write(data)
+}
+
+func (t contextTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(request.WithContext(t.ctx))
+}
+
+// sleep waits for d, or until the request behind client's transport is done.
+func sleep(client *http.Client, d time.Duration) error {
+	ctx := context.Background()
+	if transport, ok := client.Transport.(contextTransport); ok {
+		ctx = transport.ctx
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// requestContext is keyed by the request itself because appengine.NewContext
+// needs the original *http.Request, so r.WithContext can't be used.
+func requestContext(r *http.Request) context.Context {
+	requestContextsLock.Lock()
+	defer requestContextsLock.Unlock()
+	if ctx, ok := requestContexts[r]; ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+func timeoutHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if RequestTimeout <= 0 {
+			handler(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+		defer cancel()
+
+		requestContextsLock.Lock()
+		requestContexts[r] = ctx
+		requestContextsLock.Unlock()
+		defer func() {
+			requestContextsLock.Lock()
+			delete(requestContexts, r)
+			requestContextsLock.Unlock()
+		}()
+
+		tw := &timeoutResponseWriter{header: http.Header{}, status: http.StatusOK}
+		done := make(chan interface{}, 1)
+		go func() {
+			defer func() { done <- recover() }()
+			handler(tw, r)
+		}()
+
+		select {
+		case failure := <-done:
+			if failure != nil {
+				panic(failure)
+			}
+			tw.lock.Lock()
+			defer tw.lock.Unlock()
+			for key, values := range tw.header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.buffer.Bytes())
+		case <-ctx.Done():
+			tw.lock.Lock()
+			tw.timedOut = true
+			tw.lock.Unlock()
+			http.Error(w, "504 gateway timeout", http.StatusGatewayTimeout)
+
+			// The handler still uses r and its App Engine context, so
+			// wait for it to give up on the cancelled context.
+			if failure := <-done; failure != nil {
+				panic(failure)
+			}
+		}
+	}
+}
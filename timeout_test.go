@@ -0,0 +1,47 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		work    time.Duration
+		status  int
+		body    string
+	}{
+		{name: "in time", timeout: time.Second, status: http.StatusTeapot, body: "done"},
+		{name: "too slow", timeout: 20 * time.Millisecond, work: time.Second, status: http.StatusGatewayTimeout, body: "504 gateway timeout\n"},
+		{name: "disabled", work: 30 * time.Millisecond, status: http.StatusTeapot, body: "done"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, "{}")
+			RequestTimeout = test.timeout
+			handler := timeoutHandler(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(test.work):
+				case <-requestContext(r).Done():
+					return
+				}
+				w.WriteHeader(http.StatusTeapot)
+				w.Write([]byte("done"))
+			})
+
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest("GET", "/update", nil))
+			if rec.Code != test.status || rec.Body.String() != test.body {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body, test.status, test.body)
+			}
+		})
+	}
+}
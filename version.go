@@ -0,0 +1,32 @@
+package wrigi
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// Set at build time with -ldflags "-X <import path>.BuildVersion=...".
+var (
+	BuildVersion = "dev"
+	BuildCommit  = "unknown"
+	BuildTime    = "unknown"
+)
+
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildTime string
+	GoVersion string
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	response, _ := json.Marshal(BuildInfo{
+		Version:   BuildVersion,
+		Commit:    BuildCommit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
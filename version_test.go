@@ -0,0 +1,32 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestVersionHandler(t *testing.T) {
+	version, commit, built := BuildVersion, BuildCommit, BuildTime
+	BuildVersion, BuildCommit, BuildTime = "1.4.0", "0123abc", "2016-01-02T15:04:05Z"
+	t.Cleanup(func() { BuildVersion, BuildCommit, BuildTime = version, commit, built })
+
+	w := serve(httptest.NewRequest("GET", "/version", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got status %d and Content-Type %q, want 200 and JSON", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var info BuildInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	want := BuildInfo{Version: "1.4.0", Commit: "0123abc", BuildTime: "2016-01-02T15:04:05Z", GoVersion: runtime.Version()}
+	if info != want {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+}
@@ -0,0 +1,132 @@
+package wrigi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	WebhookSecret string
+
+	// pendingWebhooks holds the repositories whose webhook arrived during
+	// an update, keyed by repositoryKey. It is guarded by lastUpdateLock.
+	pendingWebhooks = map[string]bool{}
+)
+
+type releaseEvent struct {
+	Action     string `json:"action"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+func validSignature(body []byte, signature string) bool {
+	if WebhookSecret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(WebhookSecret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	c := newContext(r)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	if !validSignature(body, r.Header.Get("X-Hub-Signature-256")) {
+		c.Warningf("webhook: rejecting payload with an invalid signature")
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("X-GitHub-Event") != "release" {
+		w.Write([]byte("Ignored"))
+		return
+	}
+
+	var event releaseEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if event.Action != "published" {
+		w.Write([]byte("Ignored"))
+		return
+	}
+
+	owner, repository, ok := lookupRepository(event.Repository.Owner.Login, event.Repository.Name)
+	name := repository.Name
+	if !ok {
+		http.Error(w, "404 page not found", 404)
+		return
+	}
+
+	lastUpdateLock.Lock()
+	if updateInProgress {
+		pendingWebhooks[repositoryKey(owner, name)] = true
+		lastUpdateLock.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Queued until the current update finishes"))
+		return
+	}
+	updateInProgress = true
+	lastUpdateLock.Unlock()
+
+	cache.Delete(c, versionsCacheKey(owner, name))
+	_, err = refreshRepository(r, owner, repository, time.Now())
+	finishUpdate(r)
+
+	if err != nil {
+		c.Errorf("webhook: updating %s/%s: %v", owner, name, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Write([]byte("Repository updated"))
+}
+
+// finishUpdate refreshes the repositories queued by webhooks during the
+// update that is finishing, and then marks the update as done.
+func finishUpdate(r *http.Request) {
+	c := newContext(r)
+	for {
+		lastUpdateLock.Lock()
+		if len(pendingWebhooks) == 0 {
+			updateInProgress = false
+			lastUpdateLock.Unlock()
+			return
+		}
+		pending := pendingWebhooks
+		pendingWebhooks = map[string]bool{}
+		lastUpdateLock.Unlock()
+
+		for key := range pending {
+			parts := strings.SplitN(key, "/", 2)
+			owner, repository, ok := lookupRepository(parts[0], parts[1])
+			if !ok {
+				continue
+			}
+			cache.Delete(c, versionsCacheKey(owner, repository.Name))
+			if _, err := refreshRepository(r, owner, repository, time.Now()); err != nil {
+				c.Errorf("webhook: updating %s: %v", key, err)
+			}
+		}
+	}
+}
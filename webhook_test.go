@@ -0,0 +1,84 @@
+//go:build !appengine
+// +build !appengine
+
+package wrigi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func webhookRequest(event, body, secret string) *http.Request {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	r := httptest.NewRequest("POST", "/webhook/github", strings.NewReader(body))
+	r.Header.Set("X-GitHub-Event", event)
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestWebhookHandler(t *testing.T) {
+	published := `{"action": "published", "repository": {"name": "Plugin", "owner": {"login": "ACME"}}}`
+
+	tests := []struct {
+		name     string
+		event    string
+		body     string
+		secret   string
+		updating bool
+		status   int
+		release  string
+	}{
+		{name: "invalid signature", event: "release", body: published, secret: "wrong", status: http.StatusUnauthorized},
+		{name: "other event", event: "push", body: published, secret: "s3cret", status: http.StatusOK},
+		{name: "other action", event: "release", body: `{"action": "created", "repository": {"name": "plugin", "owner": {"login": "acme"}}}`, secret: "s3cret", status: http.StatusOK},
+		{name: "unknown repository", event: "release", body: `{"action": "published", "repository": {"name": "other", "owner": {"login": "acme"}}}`, secret: "s3cret", status: http.StatusNotFound},
+		{name: "published", event: "release", body: published, secret: "s3cret", status: http.StatusOK, release: "1.0.0"},
+		{name: "queued during an update", event: "release", body: published, secret: "s3cret", updating: true, status: http.StatusAccepted, release: "1.0.0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, `{"webhookSecret": "s3cret", "organizations": [{"name": "acme", "repositories": [{"id": "com.acme.plugin", "name": "plugin"}]}]}`)
+			stubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/acme/plugin/releases" {
+					fmt.Fprint(w, `{"stargazers_count": 10}`)
+					return
+				}
+				fmt.Fprintf(w, "[%s]", githubRelease("1.0.0", false))
+			})
+			if test.updating {
+				lastUpdateLock.Lock()
+				updateInProgress = true
+				lastUpdateLock.Unlock()
+			}
+
+			r := webhookRequest(test.event, test.body, test.secret)
+			if w := serve(r); w.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if test.updating {
+				if repository, _ := findRepository("acme", "plugin"); repository.Versions["release"].Name != "" {
+					t.Errorf("got release %q before the update finished", repository.Versions["release"].Name)
+				}
+				finishUpdate(r)
+			}
+
+			repository, _ := findRepository("acme", "plugin")
+			if got := repository.Versions["release"].Name; got != test.release {
+				t.Errorf("got release %q, want %q", got, test.release)
+			}
+			lastUpdateLock.Lock()
+			defer lastUpdateLock.Unlock()
+			if updateInProgress || len(pendingWebhooks) > 0 {
+				t.Errorf("got updateInProgress %v with %d pending webhooks after the update", updateInProgress, len(pendingWebhooks))
+			}
+		})
+	}
+}